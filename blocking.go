@@ -0,0 +1,43 @@
+package deadlock
+
+// checkBlocking reports if goroutine gid holds any locks other than except
+// when it is about to block waiting on waitOn, which may be nil.
+func (d *Detector) checkBlocking(gid int64, curStack []uintptr, waitOn, except interface{}) {
+	var held []ReportLock
+	for _, holder := range d.lo.held(gid) {
+		if holder.mtx != except {
+			held = append(held, reportLock(gid, holder.mtx, holder.stack, holder.read))
+		}
+	}
+	if len(held) > 0 {
+		r := &Report{
+			Kind:    BlockingWhileLocked,
+			Lock:    reportLock(gid, waitOn, curStack, false),
+			Holders: held,
+		}
+		if d.Opts.warnBlockingWhileLocked() {
+			r.Severity = SeverityWarning
+		}
+		d.Opts.report(r)
+	}
+}
+
+// trackedMutex returns the mutex l is tracked as, or nil if it isn't tracked.
+func trackedMutex(l interface{}) interface{} {
+	if t, ok := l.(interface{ trackedMutex() interface{} }); ok {
+		return t.trackedMutex()
+	}
+	return nil
+}
+
+func (m *DeadlockMutex) trackedMutex() interface{} {
+	return m
+}
+
+func (m *DeadlockRWMutex) trackedMutex() interface{} {
+	return m
+}
+
+func (r *rlocker) trackedMutex() interface{} {
+	return (*DeadlockRWMutex)(r)
+}
@@ -0,0 +1,95 @@
+package deadlock
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestBlocking(t *testing.T) {
+	if !Enabled {
+		t.Skip("Blocking() does nothing when deadlock checking is disabled")
+	}
+	defer restore()()
+	Opts.WriteLocked(func() { Opts.DeadlockTimeout = 0 })
+	mu, reports := captureReports()
+
+	Blocking()
+	var a DeadlockMutex
+	a.SetName("a")
+	a.Lock()
+	Blocking()
+	unlock(&a)
+
+	r := waitReports(t, mu, reports, 1)[0]
+	if r.Kind != BlockingWhileLocked || r.Severity != SeverityError || r.Lock.Mutex != nil {
+		t.Error(r.Kind, r.Severity, r.Lock.Mutex)
+	}
+	if len(r.Holders) != 1 || r.Holders[0].Mutex != &a || r.Holders[0].Goroutine != getGoid() {
+		t.Fatal(r.Holders)
+	}
+	if !hasFunction(r.Lock, "TestBlocking") || !hasFunction(r.Holders[0], "TestBlocking") {
+		t.Error(r.Lock.Stack, r.Holders[0].Stack)
+	}
+	if s := r.String(); !strings.Contains(s, "is about to block") || !strings.Contains(s, "while holding lock a (0x") {
+		t.Error(s)
+	}
+	b, err := json.Marshal(r)
+	if err != nil || strings.Contains(string(b), "%!p") || strings.Count(string(b), `"mutex":`) != 1 {
+		t.Error(string(b), err)
+	}
+}
+
+func TestBlocking_Warning(t *testing.T) {
+	defer restore()()
+	Opts.WriteLocked(func() {
+		Opts.DeadlockTimeout = 0
+		Opts.WarnBlockingWhileLocked = true
+	})
+	mu, reports := captureReports()
+
+	var a DeadlockRWMutex
+	var wg DeadlockWaitGroup
+	a.RLock()
+	wg.Wait()
+	a.RUnlock()
+
+	r := waitReports(t, mu, reports, 1)[0]
+	if r.Kind != BlockingWhileLocked || r.Severity != SeverityWarning || r.Lock.Mutex != &wg {
+		t.Error(r.Kind, r.Severity, r.Lock.Mutex)
+	}
+	if len(r.Holders) != 1 || r.Holders[0].Mutex != &a || !r.Holders[0].Read {
+		t.Error(r.Holders)
+	}
+}
+
+func TestBlocking_CondExcludesLocker(t *testing.T) {
+	defer restore()()
+	Opts.WriteLocked(func() { Opts.DeadlockTimeout = 0 })
+	mu, reports := captureReports()
+
+	var a, b DeadlockRWMutex
+	c := NewDeadlockCond(a.RLocker())
+	b.Lock()
+	a.RLock()
+	ready := false
+	go func() {
+		a.Lock()
+		ready = true
+		c.Broadcast()
+		unlock(&a)
+	}()
+	for !ready {
+		c.Wait()
+	}
+	a.RUnlock()
+	unlock(&b)
+
+	r := waitReports(t, mu, reports, 1)[0]
+	if r.Kind != BlockingWhileLocked || r.Lock.Mutex != c {
+		t.Error(r.Kind, r.Lock.Mutex)
+	}
+	if len(r.Holders) != 1 || r.Holders[0].Mutex != &b {
+		t.Error(r.Holders)
+	}
+}
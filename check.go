@@ -0,0 +1,86 @@
+package deadlock
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+)
+
+// TB is the subset of testing.TB used by Check.
+type TB interface {
+	Helper()
+	Log(args ...interface{})
+	Errorf(format string, args ...interface{})
+	Cleanup(func())
+}
+
+// Check makes potential deadlocks reported by the default Detector fail t
+// instead of panicking, until t completes. See Detector.Check.
+func Check(t TB) {
+	t.Helper()
+	defaultDetector.Check(t)
+}
+
+// Check makes potential deadlocks reported by d fail t instead of
+// panicking, until t completes. Reports are written to t.Log rather
+// than Opts.LogBuf, and the previous Options are restored when t completes.
+//
+// Tests using the default Detector must not run in parallel with each other,
+// use a Detector for each such test instead.
+func (d *Detector) Check(t TB) {
+	t.Helper()
+	var prev Options
+	d.Opts.WriteLocked(func() {
+		prev = *d.Opts
+		format := d.Opts.ReportFormat
+		d.Opts.LogBuf = nil
+		d.Opts.OnPotentialDeadlock = nil
+		d.Opts.OnReport = func(r *Report) {
+			var buf bytes.Buffer
+			r.write(&buf, format)
+			t.Log(buf.String())
+			if r.Severity != SeverityWarning {
+				t.Errorf("potential deadlock: %s", r.Kind)
+			}
+		}
+	})
+	t.Cleanup(func() {
+		d.Opts.WriteLocked(func() { *d.Opts = prev })
+	})
+}
+
+// CheckMain runs m, typically the *testing.M passed to TestMain, and returns a
+// non-zero exit code if the default Detector reported any potential deadlocks
+// meanwhile. Reports are still written to Opts.LogBuf, but do not panic.
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(deadlock.CheckMain(m))
+//	}
+func CheckMain(m interface{ Run() int }) int {
+	return defaultDetector.CheckMain(m)
+}
+
+// CheckMain is like the package function CheckMain, but checks reports by d.
+func (d *Detector) CheckMain(m interface{ Run() int }) int {
+	var prev Options
+	var count int32
+	d.Opts.WriteLocked(func() {
+		prev = *d.Opts
+		d.Opts.OnPotentialDeadlock = nil
+		d.Opts.OnReport = func(r *Report) {
+			if r.Severity != SeverityWarning {
+				atomic.AddInt32(&count, 1)
+			}
+		}
+	})
+	code := m.Run()
+	d.Opts.WriteLocked(func() { *d.Opts = prev })
+	if n := atomic.LoadInt32(&count); n > 0 {
+		_, _ = fmt.Fprintf(d.Opts, "deadlock: %d potential deadlocks reported\n", n)
+		_ = d.Opts.Flush()
+		if code == 0 {
+			code = 1
+		}
+	}
+	return code
+}
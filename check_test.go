@@ -0,0 +1,117 @@
+package deadlock
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type fakeTB struct {
+	mu       sync.Mutex
+	logs     []string
+	errors   []string
+	cleanups []func()
+}
+
+func (t *fakeTB) Helper() {}
+
+func (t *fakeTB) Log(args ...interface{}) {
+	t.mu.Lock()
+	t.logs = append(t.logs, fmt.Sprint(args...))
+	t.mu.Unlock()
+}
+
+func (t *fakeTB) Errorf(format string, args ...interface{}) {
+	t.mu.Lock()
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+	t.mu.Unlock()
+}
+
+func (t *fakeTB) Cleanup(fn func()) {
+	t.cleanups = append(t.cleanups, fn)
+}
+
+func (t *fakeTB) cleanup() {
+	for i := len(t.cleanups) - 1; i >= 0; i-- {
+		t.cleanups[i]()
+	}
+}
+
+type fakeM func() int
+
+func (m fakeM) Run() int { return m() }
+
+func lockInconsistently() {
+	var a, b DeadlockMutex
+	a.Lock()
+	b.Lock()
+	b.Unlock()
+	a.Unlock()
+	b.Lock()
+	a.Lock()
+	a.Unlock()
+	b.Unlock()
+}
+
+func TestCheck(t *testing.T) {
+	defer restore()()
+	Opts.WriteLocked(func() { Opts.DeadlockTimeout = 0 })
+	ft := &fakeTB{}
+	Check(ft)
+	lockInconsistently()
+	ft.cleanup()
+
+	if len(ft.errors) != 1 || !strings.Contains(ft.errors[0], "inconsistent locking") {
+		t.Error(ft.errors)
+	}
+	if len(ft.logs) != 1 || !strings.Contains(ft.logs[0], header) {
+		t.Error(ft.logs)
+	}
+	Opts.ReadLocked(func() {
+		if Opts.OnReport != nil || Opts.LogBuf == nil || Opts.DeadlockTimeout != 0 {
+			t.Error("options not restored")
+		}
+	})
+}
+
+func TestDetector_Check(t *testing.T) {
+	t.Parallel()
+	d := NewDetector()
+	ft := &fakeTB{}
+	d.Check(ft)
+	var a DeadlockMutex
+	a.SetDetector(d)
+	a.Lock()
+	a.Unlock()
+	ft.cleanup()
+	if len(ft.errors) != 0 {
+		t.Error(ft.errors)
+	}
+}
+
+func TestCheckMain(t *testing.T) {
+	defer restore()()
+	var buf strings.Builder
+	Opts.WriteLocked(func() {
+		Opts.DeadlockTimeout = 0
+		Opts.LogBuf = &buf
+	})
+	if code := CheckMain(fakeM(func() int { return 0 })); code != 0 {
+		t.Error(code)
+	}
+	if code := CheckMain(fakeM(func() int {
+		lockInconsistently()
+		return 0
+	})); code != 1 {
+		t.Error(code)
+	}
+	if !strings.Contains(buf.String(), "deadlock: 1 potential deadlocks reported") {
+		t.Error(buf.String())
+	}
+	Opts.ReadLocked(func() {
+		if Opts.OnReport != nil {
+			t.Error("options not restored")
+		}
+	})
+}
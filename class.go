@@ -0,0 +1,69 @@
+package deadlock
+
+import "fmt"
+
+// A LockClass makes all mutexes in it a single node in the lock order graph, so
+// that inconsistent ordering is detected even when it happens with different
+// instances, like the same field of different structs. Locking a mutex of a class
+// while holding another mutex of the same class is reported unless Nested is set.
+//
+// A LockClass is identified by its address, and must not be changed once in use.
+type LockClass struct {
+	Name string // shown in reports and the lock graph
+	// Level is the level of mutexes in the class that have none set with SetLevel.
+	Level int
+	// If set, mutexes of the class may be locked while holding another of the
+	// same class, like when locking a tree from parent to child.
+	Nested bool
+}
+
+func (c *LockClass) String() string {
+	return fmt.Sprintf("class %s", c.Name)
+}
+
+// orderKey returns the node of mtx in the lock order graph; its LockClass if it has one, or else mtx.
+// With auto set, a mutex without a class is first put in the one for the call site locking it.
+func (l *lockOrder) orderKey(mtx interface{}, stack []uintptr, auto bool) interface{} {
+	mm := metaOf(mtx)
+	if mm != nil && mm.class != nil {
+		return mm.class
+	}
+	if h, ok := mtx.(hasLockMeta); ok && auto {
+		site := lockSite(stack)
+		name := fmt.Sprintf("%s:%d", site.Function, site.Line)
+		l.classMu.Lock()
+		c := l.classes[name]
+		if c == nil {
+			c = &LockClass{Name: name}
+			l.classes[name] = c
+		}
+		l.classMu.Unlock()
+		h.lockMeta().update(func(mm *mutexMeta) {
+			if mm.class == nil {
+				mm.class = c
+			}
+		})
+		return h.lockMeta().load().class
+	}
+	return mtx
+}
+
+// heldOrderKey returns the node of a locked mutex in the lock order graph.
+func heldOrderKey(mtx interface{}) interface{} {
+	if mm := metaOf(mtx); mm != nil && mm.class != nil {
+		return mm.class
+	}
+	return mtx
+}
+
+// SetClass puts m in class c, nil meaning no class.
+// It must not be called while m is locked.
+func (m *DeadlockMutex) SetClass(c *LockClass) {
+	m.meta.update(func(mm *mutexMeta) { mm.class = c })
+}
+
+// SetClass puts m in class c, nil meaning no class.
+// It must not be called while m is locked.
+func (m *DeadlockRWMutex) SetClass(c *LockClass) {
+	m.meta.update(func(mm *mutexMeta) { mm.class = c })
+}
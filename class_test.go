@@ -0,0 +1,141 @@
+package deadlock
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLockClass(t *testing.T) {
+	defer restore()()
+	Opts.WriteLocked(func() { Opts.DeadlockTimeout = 0 })
+	mu, reports := captureReports()
+
+	classA := &LockClass{Name: "A", Level: 5}
+	classB := &LockClass{Name: "B"}
+	var a1, a2, b1 DeadlockMutex
+	var b2 DeadlockRWMutex
+	a1.SetClass(classA)
+	a2.SetClass(classA)
+	b1.SetClass(classB)
+	b2.SetClass(classB)
+
+	a1.Lock()
+	b1.Lock()
+	unlock(&b1)
+	unlock(&a1)
+	b2.RLock()
+	a2.Lock()
+	unlock(&a2)
+	runlock(&b2)
+
+	r := waitReports(t, mu, reports, 1)[0]
+	if r.Kind != InconsistentLocking || len(r.Cycle) != 2 {
+		t.Fatal(r.Kind, r.Cycle)
+	}
+	if r.Cycle[0].Before.Mutex != &a1 || r.Cycle[0].After.Mutex != &b1 {
+		t.Error(r.Cycle[0])
+	}
+	if r.Cycle[1].Before.Mutex != &b2 || r.Cycle[1].After.Mutex != &a2 {
+		t.Error(r.Cycle[1])
+	}
+	if r.Cycle[0].Before.Class != "A" || r.Cycle[0].Before.Level != 5 {
+		t.Error(r.Cycle[0].Before)
+	}
+}
+
+func TestLockClass_Nested(t *testing.T) {
+	defer restore()()
+	Opts.WriteLocked(func() { Opts.DeadlockTimeout = 0 })
+	mu, reports := captureReports()
+
+	tree := &LockClass{Name: "tree", Nested: true}
+	var parent, child DeadlockMutex
+	parent.SetClass(tree)
+	child.SetClass(tree)
+	parent.Lock()
+	child.Lock()
+	unlock(&child)
+	unlock(&parent)
+
+	account := &LockClass{Name: "account"}
+	var from, to DeadlockMutex
+	from.SetClass(account)
+	to.SetClass(account)
+	from.Lock()
+	to.Lock()
+	unlock(&to)
+	unlock(&from)
+
+	r := waitReports(t, mu, reports, 1)[0]
+	if r.Kind != NestedLocking || r.Lock.Mutex != &to || len(r.Holders) != 1 || r.Holders[0].Mutex != &from {
+		t.Error(r.Kind, r.Lock, r.Holders)
+	}
+	if s := r.String(); !strings.Contains(s, "Nested locking of class account:") {
+		t.Error(s)
+	}
+}
+
+func TestLockClass_NoLockOrder(t *testing.T) {
+	d, mu, reports := newTestDetector()
+	d.Opts.WriteLocked(func() { d.Opts.MaxMapSize = 0 })
+
+	// classes are checked without lock order tracking
+	account := &LockClass{Name: "account"}
+	var from, to DeadlockMutex
+	from.SetDetector(d)
+	to.SetDetector(d)
+	from.SetClass(account)
+	to.SetClass(account)
+	from.Lock()
+	to.Lock()
+	unlock(&to)
+	unlock(&from)
+
+	r := waitReports(t, mu, reports, 1)[0]
+	if r.Kind != NestedLocking || r.Lock.Mutex != &to || len(r.Holders) != 1 || r.Holders[0].Mutex != &from {
+		t.Error(r.Kind, r.Lock, r.Holders)
+	}
+}
+
+type classPair struct {
+	x, y DeadlockMutex
+}
+
+func (p *classPair) init() {
+	p.x.Lock()
+	unlock(&p.x)
+	p.y.Lock()
+	unlock(&p.y)
+}
+
+func TestAutoLockClasses(t *testing.T) {
+	defer restore()()
+	Opts.WriteLocked(func() {
+		Opts.DeadlockTimeout = 0
+		Opts.AutoLockClasses = true
+	})
+	mu, reports := captureReports()
+
+	var p1, p2 classPair
+	p1.init()
+	p2.init()
+	p1.x.Lock()
+	p1.y.Lock()
+	unlock(&p1.y)
+	unlock(&p1.x)
+	p2.y.Lock()
+	p2.x.Lock()
+	unlock(&p2.x)
+	unlock(&p2.y)
+
+	r := waitReports(t, mu, reports, 1)[0]
+	if r.Kind != InconsistentLocking || len(r.Cycle) != 2 {
+		t.Fatal(r.Kind, r.Cycle)
+	}
+	if r.Cycle[0].Before.Mutex != &p1.x || r.Cycle[1].Before.Mutex != &p2.y {
+		t.Error(r.Cycle)
+	}
+	if !strings.Contains(r.Cycle[0].Before.Class, "classPair).init:") {
+		t.Error(r.Cycle[0].Before.Class)
+	}
+}
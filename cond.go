@@ -0,0 +1,95 @@
+package deadlock
+
+import (
+	"sync"
+)
+
+// A DeadlockCond is a drop-in replacement for sync.Cond.
+//
+// Waiting for longer than Opts.DeadlockTimeout is reported together
+// with where the DeadlockCond was last signalled. It uses the Detector
+// its Locker is bound to.
+type DeadlockCond struct {
+	// L is held while observing or changing the condition
+	L sync.Locker
+
+	once   sync.Once
+	cond   sync.Cond
+	mu     sync.Mutex // protects following
+	signal stackGID   // last call to Signal or Broadcast
+}
+
+// NewDeadlockCond returns a new DeadlockCond with Locker l.
+func NewDeadlockCond(l sync.Locker) *DeadlockCond {
+	return &DeadlockCond{L: l}
+}
+
+type condLocker DeadlockCond
+
+func (c *condLocker) Lock()   { c.L.Lock() }
+func (c *condLocker) Unlock() { c.L.Unlock() }
+
+func (c *DeadlockCond) init() {
+	c.once.Do(func() { c.cond.L = (*condLocker)(c) })
+}
+
+// Wait atomically unlocks c.L and suspends execution
+// of the calling goroutine. After later resuming execution,
+// Wait locks c.L before returning. Unlike in other systems,
+// Wait cannot return unless awoken by Broadcast or Signal.
+//
+// Logs potential deadlocks to Opts.LogBuf,
+// calling Opts.OnPotentialDeadlock on each occasion.
+func (c *DeadlockCond) Wait() {
+	c.init()
+	gid := getGoid()
+	curStack := callers(1)
+	l := trackedMutex(c.L)
+	d := detectorOf(l)
+	d.checkBlocking(gid, curStack, c, l)
+	if w := d.startWait(CondTimeout, gid, curStack, c, false); w != nil {
+		defer d.endWait(w)
+	}
+	c.cond.Wait()
+}
+
+// Signal wakes one goroutine waiting on c, if there is any.
+func (c *DeadlockCond) Signal() {
+	c.init()
+	c.signaled()
+	c.cond.Signal()
+}
+
+// Broadcast wakes all goroutines waiting on c.
+func (c *DeadlockCond) Broadcast() {
+	c.init()
+	c.signaled()
+	c.cond.Broadcast()
+}
+
+func (c *DeadlockCond) signaled() {
+	signal := stackGID{stack: callers(2), gid: getGoid()}
+	c.mu.Lock()
+	c.signal = signal
+	c.mu.Unlock()
+}
+
+// reportTimeout reports w as having waited on c for longer than w.timeout.
+func (c *DeadlockCond) reportTimeout(d *Detector, w *waiter) {
+	r := &Report{
+		Kind:    CondTimeout,
+		Lock:    reportLock(w.gid, c, w.stack, false),
+		Timeout: w.timeout,
+	}
+	c.mu.Lock()
+	signal := c.signal
+	c.mu.Unlock()
+	if signal.stack != nil {
+		r.Holders = append(r.Holders, reportLock(signal.gid, c, signal.stack, false))
+	}
+	r.Others = d.lo.otherLocked(nil)
+	if d.Opts.PrintAllCurrentGoroutinesEnabled() {
+		r.AllGoroutines = string(stacks())
+	}
+	d.Opts.report(r)
+}
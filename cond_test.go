@@ -0,0 +1,88 @@
+package deadlock
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeadlockCond(t *testing.T) {
+	defer restore()()
+	var mu DeadlockMutex
+	c := NewDeadlockCond(&mu)
+	ready := false
+	done := make(chan int64)
+	go func() {
+		mu.Lock()
+		for !ready {
+			c.Wait()
+		}
+		holders := defaultDetector.lo.holders(&mu)
+		mu.Unlock()
+		if len(holders) != 1 {
+			t.Error(holders)
+			done <- 0
+			return
+		}
+		done <- holders[0].gid
+	}()
+	time.Sleep(time.Millisecond)
+	mu.Lock()
+	ready = true
+	c.Broadcast()
+	mu.Unlock()
+	waiter := <-done
+	if waiter == 0 || waiter == getGoid() {
+		t.Error("expected lock to be held by waiter, got goroutine", waiter)
+	}
+	n := len(defaultDetector.lo.holders(&mu))
+	if n != 0 {
+		t.Error("expected mutex to be unlocked")
+	}
+}
+
+func TestDeadlockCond_Timeout(t *testing.T) {
+	defer restore()()
+	Opts.WriteLocked(func() { Opts.DeadlockTimeout = time.Millisecond * 20 })
+	mu, reports := captureReports()
+
+	var l DeadlockMutex
+	c := NewDeadlockCond(&l)
+	c.Signal() // nobody waiting, but should be reported as last signaller
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.Lock()
+		c.Wait()
+		l.Unlock()
+	}()
+	r := waitReports(t, mu, reports, 1)[0]
+	l.Lock()
+	c.Signal()
+	l.Unlock()
+	<-done
+
+	if r.Kind != CondTimeout || r.Lock.Mutex != c {
+		t.Error(r.Kind, r.Lock.Mutex)
+	}
+	if len(r.Holders) != 1 || r.Holders[0].Goroutine != getGoid() || !hasFunction(r.Holders[0], "TestDeadlockCond_Timeout") {
+		t.Error(r.Holders)
+	}
+}
+
+func TestNewCond(t *testing.T) {
+	var mu Mutex
+	c := NewCond(&mu)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	mu.Lock()
+	go func() {
+		defer wg.Done()
+		mu.Lock()
+		c.Signal()
+		mu.Unlock()
+	}()
+	c.Wait()
+	mu.Unlock()
+	wg.Wait()
+}
@@ -4,12 +4,14 @@
 package deadlock
 
 import (
+	"context"
 	"sync"
 )
 
 // A DeadlockMutex is a drop-in replacement for sync.Mutex.
 type DeadlockMutex struct {
-	mu sync.Mutex
+	mu   sync.Mutex
+	meta lockMeta
 }
 
 // Lock locks the mutex.
@@ -19,7 +21,13 @@ type DeadlockMutex struct {
 // Logs potential deadlocks to Opts.LogBuf,
 // calling Opts.OnPotentialDeadlock on each occasion.
 func (m *DeadlockMutex) Lock() {
-	lock(nil, m.mu.Lock, m)
+	lock(m.meta.detector(), nil, m.mu.Lock, m, false)
+}
+
+// LockContext locks the mutex like Lock, but returns ctx.Err()
+// without locking if ctx is done before the mutex is available.
+func (m *DeadlockMutex) LockContext(ctx context.Context) error {
+	return lockContext(ctx, m.meta.detector(), nil, m.mu.Lock, m.mu.Unlock, m, false)
 }
 
 // Unlock unlocks the mutex.
@@ -29,13 +37,16 @@ func (m *DeadlockMutex) Lock() {
 // It is allowed for one goroutine to lock a Mutex and then
 // arrange for another goroutine to unlock it.
 func (m *DeadlockMutex) Unlock() {
+	d := m.meta.detector()
+	d.preUnlock(m, false)
 	m.mu.Unlock()
-	lo.postUnlock(m)
+	d.lo.postUnlock(getGoid(), m)
 }
 
 // An DeadlockRWMutex is a drop-in replacement for sync.RWMutex.
 type DeadlockRWMutex struct {
-	mu sync.RWMutex
+	mu   sync.RWMutex
+	meta lockMeta
 }
 
 // Lock locks rw for writing.
@@ -48,7 +59,13 @@ type DeadlockRWMutex struct {
 // Logs potential deadlocks to Opts.LogBuf,
 // calling Opts.OnPotentialDeadlock on each occasion.
 func (m *DeadlockRWMutex) Lock() {
-	lock(nil, m.mu.Lock, m)
+	lock(m.meta.detector(), nil, m.mu.Lock, m, false)
+}
+
+// LockContext locks rw for writing like Lock, but returns ctx.Err()
+// without locking if ctx is done before the lock is available.
+func (m *DeadlockRWMutex) LockContext(ctx context.Context) error {
+	return lockContext(ctx, m.meta.detector(), nil, m.mu.Lock, m.mu.Unlock, m, false)
 }
 
 // Unlock unlocks the mutex for writing.  It is a run-time error if rw is
@@ -58,8 +75,10 @@ func (m *DeadlockRWMutex) Lock() {
 // goroutine.  One goroutine may RLock (Lock) an RWMutex and then
 // arrange for another goroutine to RUnlock (Unlock) it.
 func (m *DeadlockRWMutex) Unlock() {
+	d := m.meta.detector()
+	d.preUnlock(m, false)
 	m.mu.Unlock()
-	lo.postUnlock(m)
+	d.lo.postUnlock(getGoid(), m)
 }
 
 // RLock locks the mutex for reading.
@@ -67,7 +86,13 @@ func (m *DeadlockRWMutex) Unlock() {
 // Logs potential deadlocks to Opts.LogBuf,
 // calling Opts.OnPotentialDeadlock on each occasion.
 func (m *DeadlockRWMutex) RLock() {
-	lock(nil, m.mu.RLock, m)
+	lock(m.meta.detector(), nil, m.mu.RLock, m, true)
+}
+
+// RLockContext locks the mutex for reading like RLock, but returns ctx.Err()
+// without locking if ctx is done before the lock is available.
+func (m *DeadlockRWMutex) RLockContext(ctx context.Context) error {
+	return lockContext(ctx, m.meta.detector(), nil, m.mu.RLock, m.mu.RUnlock, m, true)
 }
 
 // RUnlock undoes a single RLock call;
@@ -75,6 +100,8 @@ func (m *DeadlockRWMutex) RLock() {
 // It is a run-time error if rw is not locked for reading
 // on entry to RUnlock.
 func (m *DeadlockRWMutex) RUnlock() {
+	d := m.meta.detector()
+	d.preUnlock(m, true)
 	m.mu.RUnlock()
-	lo.postUnlock(m)
+	d.lo.postRUnlock(getGoid(), m)
 }
@@ -4,8 +4,10 @@
 package deadlock
 
 import (
+	"context"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestDeadlockMutex_TryLock(t *testing.T) {
@@ -42,3 +44,51 @@ func TestDeadlockMutex_TryLock(t *testing.T) {
 		t.Fatal("got", deadlocks, "deadlocks, expected none")
 	}
 }
+
+func TestStats_Contended(t *testing.T) {
+	defer restore()()
+	defer ResetStats()
+	Opts.WriteLocked(func() {
+		Opts.CollectStats = true
+	})
+
+	var a DeadlockMutex
+	a.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Lock()
+		a.Unlock()
+	}()
+	time.Sleep(time.Millisecond * 10)
+	a.Unlock()
+	<-done
+
+	if ls := lockStatsFor(&a); ls.Acquisitions != 2 || ls.Contended != 1 {
+		t.Error(ls)
+	}
+}
+
+func TestLockContext_Uncontended(t *testing.T) {
+	if Enabled {
+		t.Skip("deadlock checking allocates when enabled")
+	}
+	var a Mutex
+	var b RWMutex
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	allocs := testing.AllocsPerRun(100, func() {
+		if a.LockContext(ctx) == nil {
+			a.Unlock()
+		}
+		if b.LockContext(ctx) == nil {
+			b.Unlock()
+		}
+		if b.RLockContext(ctx) == nil {
+			b.RUnlock()
+		}
+	})
+	if allocs != 0 {
+		t.Error("expected no allocations, got", allocs)
+	}
+}
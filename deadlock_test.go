@@ -1,6 +1,7 @@
 package deadlock
 
 import (
+	"context"
 	"math/rand"
 	"runtime"
 	"sync"
@@ -56,7 +57,7 @@ func doUnLock(l sync.Locker, load *int32) {
 func TestDummyLock(t *testing.T) {
 	// to keep full test coverage even though the code path
 	// is never taken on versions of go prior to 1.18
-	lock(nil, nil, nil)
+	lock(defaultDetector, nil, nil, nil, false)
 }
 
 func TestNoDeadlocks(t *testing.T) {
@@ -142,6 +143,36 @@ func TestLockOrder(t *testing.T) {
 	spinWait(t, &deadlocks, 1)
 }
 
+func TestLockOrderCycle(t *testing.T) {
+	defer restore()()
+	var deadlocks uint32
+	Opts.WriteLocked(func() {
+		Opts.DeadlockTimeout = 0
+		Opts.OnPotentialDeadlock = func() {
+			atomic.AddUint32(&deadlocks, 1)
+		}
+	})
+
+	var a, b, c DeadlockMutex
+	lockPair := func(m1, m2 *DeadlockMutex) {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			m1.Lock()
+			m2.Lock()
+			unlock(m2)
+			unlock(m1)
+		}()
+		<-done
+	}
+
+	lockPair(&a, &b)
+	lockPair(&b, &c)
+	spinWait(t, &deadlocks, 0)
+	lockPair(&c, &a)
+	spinWait(t, &deadlocks, 1)
+}
+
 func TestHardDeadlock(t *testing.T) {
 	defer restore()()
 	var deadlocks uint32
@@ -323,12 +354,12 @@ func TestManyReadersFewWriters(t *testing.T) {
 
 // TestConcurrentLockOrderDetection verifies that lock-order violation detection
 // works correctly under real goroutine contention. TestLockOrder runs its two
-// goroutines sequentially (wg.Wait() between them), so the order map and cur map
+// goroutines sequentially (wg.Wait() between them), so the order map and lock sets
 // are only contested by one goroutine at a time. Here, many goroutines
 // simultaneously call preLock, postLock, and postUnlock — all contending on
-// lo.mu — while each one independently detects the same A→B vs B→A conflict.
-// This stresses concurrent iteration of lo.cur, concurrent reads/writes to
-// lo.order, and concurrent invocations of OnPotentialDeadlock.
+// defaultDetector.lo — while each one independently detects the same A→B vs B→A conflict.
+// This stresses concurrent access to the lock sets, concurrent reads/writes to
+// defaultDetector.lo.order, and concurrent invocations of OnPotentialDeadlock.
 func TestConcurrentLockOrderDetection(t *testing.T) {
 	defer restore()()
 	var deadlocks uint32
@@ -375,6 +406,55 @@ func TestConcurrentLockOrderDetection(t *testing.T) {
 	}
 }
 
+// TestLockOrderCycleRace checks that two goroutines adding opposite edges
+// between checking for cycles and adding them still report the cycle.
+func TestLockOrderCycleRace(t *testing.T) {
+	d, _, _ := newTestDetector()
+	l := d.lo
+	var a, b DeadlockMutex
+	stack := callers(0)
+	heldA := []heldLock{{mtx: &a, stackGID: stackGID{stack: stack, gid: 1}}}
+	heldB := []heldLock{{mtx: &b, stackGID: stackGID{stack: stack, gid: 2}}}
+
+	reports1, adds1, added1 := l.checkOrder(heldA, 1, stack, &b, false, false, true, nil)
+	reports2, adds2, added2 := l.checkOrder(heldB, 2, stack, &a, false, false, true, nil)
+	if len(reports1) != 0 || len(reports2) != 0 || len(adds1) != 1 || len(adds2) != 1 {
+		t.Fatal(reports1, reports2, adds1, adds2)
+	}
+	if reports := l.addOrders(1000, adds1, added1, 1, stack, false); len(reports) != 0 {
+		t.Error(reports)
+	}
+	reports := l.addOrders(1000, adds2, added2, 2, stack, false)
+	if len(reports) != 1 || reports[0].Kind != InconsistentLocking || len(reports[0].Cycle) != 2 {
+		t.Fatal(reports)
+	}
+	if r := reports[0]; r.Lock.Mutex != &a || r.Cycle[0].Before.Mutex != &a || r.Cycle[1].Before.Mutex != &b {
+		t.Error(r.Lock, r.Cycle)
+	}
+}
+
+func TestLockOrderSeen(t *testing.T) {
+	d, _, _ := newTestDetector()
+	var a, b DeadlockMutex
+	a.SetDetector(d)
+	b.SetDetector(d)
+	lockAB := func() {
+		a.Lock()
+		b.Lock()
+		unlock(&b)
+		unlock(&a)
+	}
+	lockAB()
+	seen := atomic.LoadUint64(&d.lo.seen)
+	for i := 0; i < 10; i++ {
+		lockAB()
+	}
+	// recently seen edges are not refreshed
+	if got := atomic.LoadUint64(&d.lo.seen); got != seen {
+		t.Error("expected", seen, "got", got)
+	}
+}
+
 func TestLockDuplicate(t *testing.T) {
 	defer restore()()
 	var deadlocks uint32
@@ -425,6 +505,121 @@ func TestLockDuplicate(t *testing.T) {
 	}
 }
 
+func TestRecursiveRLock(t *testing.T) {
+	defer restore()()
+	var deadlocks, warnings uint32
+	Opts.WriteLocked(func() {
+		Opts.DeadlockTimeout = 0
+		Opts.OnPotentialDeadlock = func() {
+			atomic.AddUint32(&deadlocks, 1)
+		}
+		Opts.OnReport = func(r *Report) {
+			if r.Kind == RecursiveRLocking && r.Severity == SeverityWarning {
+				atomic.AddUint32(&warnings, 1)
+			}
+		}
+	})
+
+	var a DeadlockRWMutex
+	a.RLock()
+	a.RLock()
+	runlock(&a)
+	runlock(&a)
+	if atomic.LoadUint32(&warnings) != 1 || atomic.LoadUint32(&deadlocks) != 0 {
+		t.Error("expected one warning and no deadlocks, got", warnings, deadlocks)
+	}
+
+	Opts.WriteLocked(func() { Opts.AllowRecursiveRLock = true })
+	a.RLock()
+	a.RLock()
+	runlock(&a)
+	runlock(&a)
+	if atomic.LoadUint32(&warnings) != 1 || atomic.LoadUint32(&deadlocks) != 0 {
+		t.Error("expected recursive read lock to be allowed, got", warnings, deadlocks)
+	}
+}
+
+func TestLockContext(t *testing.T) {
+	defer restore()()
+	var deadlocks uint32
+	Opts.WriteLocked(func() {
+		Opts.DeadlockTimeout = time.Millisecond * 20
+		Opts.OnPotentialDeadlock = func() {
+			atomic.AddUint32(&deadlocks, 1)
+		}
+	})
+
+	var a DeadlockRWMutex
+	if err := a.LockContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if err := a.RLockContext(ctx); err != context.DeadlineExceeded {
+		t.Error("expected DeadlineExceeded, got", err)
+	}
+	if err := a.LockContext(ctx); err != context.DeadlineExceeded {
+		t.Error("expected DeadlineExceeded, got", err)
+	}
+	spinWait(t, &deadlocks, 2) // recursive locking and timeout
+	unlock(&a)
+
+	if err := a.RLockContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	runlock(&a)
+
+	var b DeadlockMutex
+	b.Lock()
+	done := make(chan error)
+	go func() {
+		err := b.LockContext(context.Background())
+		if err == nil {
+			b.Unlock()
+		}
+		done <- err
+	}()
+	time.Sleep(time.Millisecond * 5)
+	unlock(&b)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+
+	n := len(defaultDetector.lo.holders(&a)) + len(defaultDetector.lo.holders(&b))
+	if n != 0 {
+		t.Error("expected no locks held, got", n)
+	}
+}
+
+func TestMutex_LockContext(t *testing.T) {
+	var a Mutex
+	var b RWMutex
+	ctx, cancel := context.WithCancel(context.Background())
+	locked := make(chan struct{})
+	go func() {
+		defer close(locked)
+		if err := a.LockContext(ctx); err != nil {
+			t.Error(err)
+		}
+		if err := b.RLockContext(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-locked
+	go cancel()
+	if err := a.LockContext(ctx); err != context.Canceled {
+		t.Error("expected Canceled, got", err)
+	}
+	if err := b.LockContext(ctx); err != context.Canceled {
+		t.Error("expected Canceled, got", err)
+	}
+	if err := b.RLockContext(ctx); err != context.Canceled {
+		t.Error("expected Canceled, got", err)
+	}
+	a.Unlock()
+	b.RUnlock()
+}
+
 //go:noinline
 func lockOne(m *DeadlockMutex) {
 	m.Lock()
@@ -0,0 +1,107 @@
+package deadlock
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxReportSignatures bounds the number of report signatures remembered for DedupReports.
+const maxReportSignatures = 4096
+
+type reportSeen struct {
+	last  time.Time // when last reported
+	count int       // occurrences not yet reported
+}
+
+type reportDedup struct {
+	mu   sync.Mutex // protects following
+	seen map[uint64]*reportSeen
+}
+
+// signature returns a hash of the kind of r and the stacks of the locks involved,
+// so that the same problem found again at the same call sites has the same signature.
+func (r *Report) signature() uint64 {
+	h := fnv.New64a()
+	var b [8]byte
+	put := func(v uint64) {
+		binary.LittleEndian.PutUint64(b[:], v)
+		_, _ = h.Write(b[:])
+	}
+	putStack := func(frames []runtime.Frame) {
+		put(uint64(len(frames)))
+		for _, frame := range frames {
+			put(uint64(frame.PC))
+		}
+	}
+	put(uint64(r.Kind))
+	putStack(r.Lock.Stack)
+	for _, holder := range r.Holders {
+		putStack(holder.Stack)
+	}
+	for _, edge := range r.Cycle {
+		putStack(edge.Before.Stack)
+		putStack(edge.After.Stack)
+	}
+	return h.Sum64()
+}
+
+// seenBefore returns true if r should not be reported because one with the same signature
+// was reported less than interval ago, or ever if interval is zero.
+// Otherwise it sets r.Count to the number of occurrences since the last report.
+func (rd *reportDedup) seenBefore(r *Report, interval time.Duration) bool {
+	sig := r.signature()
+	now := time.Now()
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	rs := rd.seen[sig]
+	if rs == nil {
+		if len(rd.seen) >= maxReportSignatures {
+			// in batches so that the cost of finding them is spread over many reports
+			rd.evict(len(rd.seen) - maxReportSignatures + 1 + maxReportSignatures/16)
+		}
+		rs = &reportSeen{}
+		if rd.seen == nil {
+			rd.seen = map[uint64]*reportSeen{}
+		}
+		rd.seen[sig] = rs
+	} else if interval <= 0 || now.Sub(rs.last) < interval {
+		rs.count++
+		return true
+	}
+	r.Count = rs.count + 1
+	rs.count = 0
+	rs.last = now
+	return false
+}
+
+// evict forgets the n signatures least recently reported.
+func (rd *reportDedup) evict(n int) {
+	last := make([]time.Time, 0, len(rd.seen))
+	for _, rs := range rd.seen {
+		last = append(last, rs.last)
+	}
+	sort.Slice(last, func(i, j int) bool { return last[i].Before(last[j]) })
+	if n > len(last) {
+		n = len(last)
+	}
+	if n < 1 {
+		return
+	}
+	cutoff := last[n-1]
+	for sig, rs := range rd.seen {
+		if !rs.last.After(cutoff) {
+			delete(rd.seen, sig)
+		}
+	}
+}
+
+// reset forgets all signatures seen.
+func (rd *reportDedup) reset() {
+	rd.mu.Lock()
+	rd.seen = nil
+	rd.mu.Unlock()
+}
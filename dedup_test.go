@@ -0,0 +1,126 @@
+package deadlock
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDedupReports(t *testing.T) {
+	d, mu, reports := newTestDetector()
+	d.Opts.WriteLocked(func() {
+		d.Opts.DedupReports = true
+	})
+
+	var a, b DeadlockMutex
+	a.SetDetector(d)
+	b.SetDetector(d)
+	a.Lock()
+	b.Lock()
+	unlock(&b)
+	unlock(&a)
+	for i := 0; i < 10; i++ {
+		b.Lock()
+		a.Lock()
+		unlock(&a)
+		unlock(&b)
+	}
+
+	r := waitReports(t, mu, reports, 1)[0]
+	if r.Kind != InconsistentLocking || r.Count != 1 {
+		t.Error(r.Kind, r.Count)
+	}
+}
+
+func TestDedupReports_Interval(t *testing.T) {
+	d, mu, reports := newTestDetector()
+	d.Opts.WriteLocked(func() {
+		d.Opts.DedupReports = true
+		d.Opts.ReportInterval = time.Millisecond * 20
+	})
+
+	var a, b DeadlockMutex
+	a.SetDetector(d)
+	b.SetDetector(d)
+	a.Lock()
+	b.Lock()
+	unlock(&b)
+	unlock(&a)
+	for i := 0; i < 6; i++ {
+		if i == 5 {
+			time.Sleep(time.Millisecond * 30)
+		}
+		b.Lock()
+		a.Lock()
+		unlock(&a)
+		unlock(&b)
+	}
+
+	got := waitReports(t, mu, reports, 2)
+	if got[0].Count != 1 || got[1].Count != 5 {
+		t.Error(got[0].Count, got[1].Count)
+	}
+	if !strings.Contains(got[1].String(), "Occurred 5 times since last reported.") {
+		t.Error(got[1].String())
+	}
+}
+
+func TestDedupReports_Reset(t *testing.T) {
+	d, mu, reports := newTestDetector()
+	d.Opts.WriteLocked(func() {
+		d.Opts.DedupReports = true
+	})
+
+	var a, b DeadlockMutex
+	a.SetDetector(d)
+	b.SetDetector(d)
+	a.Lock()
+	b.Lock()
+	unlock(&b)
+	unlock(&a)
+	for i := 0; i < 4; i++ {
+		if i == 2 {
+			// changing the settings forgets what was reported
+			d.Opts.WriteLocked(func() {
+				d.Opts.ReportInterval = time.Hour
+			})
+		}
+		b.Lock()
+		a.Lock()
+		unlock(&a)
+		unlock(&b)
+	}
+
+	got := waitReports(t, mu, reports, 2)
+	if got[0].Count != 1 || got[1].Count != 1 {
+		t.Error(got[0].Count, got[1].Count)
+	}
+}
+
+func TestReportDedup_Evict(t *testing.T) {
+	var rd reportDedup
+	for i := 0; i < maxReportSignatures; i++ {
+		r := &Report{Kind: InconsistentLocking, Lock: ReportLock{Stack: []runtime.Frame{{PC: uintptr(i + 1)}}}}
+		if rd.seenBefore(r, 0) {
+			t.Fatal(i)
+		}
+	}
+	first := &Report{Kind: InconsistentLocking, Lock: ReportLock{Stack: []runtime.Frame{{PC: 1}}}}
+	last := &Report{Kind: InconsistentLocking, Lock: ReportLock{Stack: []runtime.Frame{{PC: maxReportSignatures}}}}
+	if !rd.seenBefore(last, 0) {
+		t.Error("expected the last report to be remembered")
+	}
+	if rd.seenBefore(&Report{Kind: LockLevel}, 0) {
+		t.Error("expected a new report to not be seen")
+	}
+	if len(rd.seen) >= maxReportSignatures || len(rd.seen) < maxReportSignatures*7/8 {
+		t.Error(len(rd.seen))
+	}
+	if !rd.seenBefore(last, 0) {
+		t.Error("expected the most recent reports to be kept")
+	}
+	if rd.seenBefore(first, 0) {
+		t.Error("expected the oldest report to be evicted")
+	}
+}
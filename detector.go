@@ -0,0 +1,81 @@
+package deadlock
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// A Detector tracks the locks bound to it and reports potential deadlocks
+// among them according to its own Options. Locks bound to different
+// Detectors are never checked against each other.
+//
+// Locks not bound to a Detector with SetDetector use the default Detector,
+// which is configured by the global Opts.
+type Detector struct {
+	// Opts control how the Detector behaves.
+	// To safely read or change them during runtime, use Opts.ReadLocked() and Opts.WriteLocked()
+	// The pointer itself must not be changed; assign to *Opts instead.
+	Opts *Options
+
+	optsLock sync.RWMutex // protects *Opts
+
+	// copies of Opts that are read without locking, updated by Opts.WriteLocked
+	maxMapSize        int32
+	deadlockTimeout   int32
+	watchdogInterval  int32
+	maxHoldTime       int32
+	collectStats      int32
+	checkUnlock       int32
+	warnForeignUnlock int32
+	autoLockClasses   int32
+	detectWaitCycles  int32
+
+	lo      *lockOrder
+	stats   lockStats
+	reports reportDedup
+	waiters waiters
+}
+
+const (
+	defaultMaxMapSize      = 1024 * 64
+	defaultDeadlockTimeout = time.Second * 30
+	// how often the watchdog checks for wait cycles if there is no DeadlockTimeout or WatchdogInterval
+	defaultWatchdogInterval = time.Millisecond * 100
+)
+
+var defaultDetector = newDetector(&Opts)
+
+// detectors maps the Options of each Detector to it, so that their methods
+// find the Detector whatever is assigned to them.
+var detectors sync.Map
+
+// NewDetector returns a new Detector with the default Options.
+// Detectors are never garbage collected, so create them once, not per use.
+func NewDetector() *Detector {
+	opts := &Options{
+		DeadlockTimeout: defaultDeadlockTimeout,
+		MaxMapSize:      defaultMaxMapSize,
+		LogBuf:          os.Stderr,
+	}
+	return newDetector(opts)
+}
+
+func newDetector(opts *Options) *Detector {
+	d := &Detector{
+		Opts:  opts,
+		stats: lockStats{m: map[interface{}]*LockStats{}},
+	}
+	d.lo = newLockOrder(d)
+	d.load()
+	detectors.Store(opts, d)
+	return d
+}
+
+// detectorOf returns the Detector mtx is bound to.
+func detectorOf(mtx interface{}) *Detector {
+	if h, ok := mtx.(hasLockMeta); ok {
+		return h.lockMeta().detector()
+	}
+	return defaultDetector
+}
@@ -0,0 +1,113 @@
+package deadlock
+
+import (
+	"sync"
+	"testing"
+)
+
+func newTestDetector() (*Detector, *sync.Mutex, *[]*Report) {
+	var mu sync.Mutex
+	var reports []*Report
+	d := NewDetector()
+	d.Opts.WriteLocked(func() {
+		d.Opts.DeadlockTimeout = 0
+		d.Opts.LogBuf = nil
+		d.Opts.OnReport = func(r *Report) {
+			mu.Lock()
+			reports = append(reports, r)
+			mu.Unlock()
+		}
+	})
+	return d, &mu, &reports
+}
+
+func TestDetector(t *testing.T) {
+	t.Parallel()
+	d1, mu1, reports1 := newTestDetector()
+	d2, mu2, reports2 := newTestDetector()
+
+	// a and b are locked in opposite orders, but bound to different detectors.
+	var a, b DeadlockMutex
+	a.SetDetector(d1)
+	b.SetDetector(d2)
+	a.Lock()
+	b.Lock()
+	unlock(&b)
+	unlock(&a)
+	b.Lock()
+	a.Lock()
+	unlock(&a)
+	unlock(&b)
+
+	var c DeadlockRWMutex
+	c.SetDetector(d1)
+	c.Lock()
+	a.Lock()
+	unlock(&a)
+	unlock(&c)
+	a.Lock()
+	c.RLock()
+	runlock(&c)
+	unlock(&a)
+
+	waitReports(t, mu2, reports2, 0)
+	r := waitReports(t, mu1, reports1, 1)[0]
+	if r.Kind != InconsistentLocking || len(r.Cycle) != 2 {
+		t.Fatal(r.Kind, r.Cycle)
+	}
+	if r.Cycle[0].Before.Mutex != &c || r.Cycle[0].After.Mutex != &a {
+		t.Error(r.Cycle[0])
+	}
+	defaultDetector.lo.mu.RLock()
+	_, ok := defaultDetector.lo.order[beforeAfterMtx{&c, &a}]
+	defaultDetector.lo.mu.RUnlock()
+	if ok {
+		t.Error("default detector learned lock order of d1")
+	}
+}
+
+func TestDetector_WaitGroup(t *testing.T) {
+	t.Parallel()
+	d, mu, reports := newTestDetector()
+
+	var a DeadlockMutex
+	var wg DeadlockWaitGroup
+	a.SetDetector(d)
+	wg.SetDetector(d)
+	a.Lock()
+	wg.Wait()
+	unlock(&a)
+
+	r := waitReports(t, mu, reports, 1)[0]
+	if r.Kind != BlockingWhileLocked || r.Lock.Mutex != &wg {
+		t.Error(r.Kind, r.Lock)
+	}
+}
+
+func TestDetector_Options(t *testing.T) {
+	t.Parallel()
+	d := NewDetector()
+	if d.Opts.DeadlockTimeout != defaultDeadlockTimeout || d.Opts.MaxMapSize != defaultMaxMapSize {
+		t.Error(d.Opts)
+	}
+	d.Opts.WriteLocked(func() { *d.Opts = Options{MaxMapSize: 1} })
+	if d.Opts.detector() != d || d.maxMapSize != 1 || d.deadlockTimeout != 0 {
+		t.Error("options not bound to their detector")
+	}
+	if Opts.detector() != defaultDetector || defaultDetector.Opts != &Opts {
+		t.Error("Opts not bound to the default detector")
+	}
+}
+
+func TestDetector_AssignOptions(t *testing.T) {
+	t.Parallel()
+	d := NewDetector()
+	*d.Opts = Options{MaxMapSize: 100}
+	d.Opts.WriteLocked(func() { d.Opts.DeadlockTimeout = 0 })
+	if d.Opts.detector() != d || d.maxMapSize != 100 || d.deadlockTimeout != 0 {
+		t.Error("assigned options not bound to their detector", d.maxMapSize, d.deadlockTimeout)
+	}
+	if defaultDetector.maxMapSize != int32(Opts.MaxMapSize) {
+		t.Error("default detector changed")
+	}
+}
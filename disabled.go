@@ -3,7 +3,10 @@
 
 package deadlock
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 // Mutex is sync.Mutex wrapper
 type Mutex struct{ sync.Mutex }
@@ -11,5 +14,95 @@ type Mutex struct{ sync.Mutex }
 // RWMutex is sync.RWMutex wrapper
 type RWMutex struct{ sync.RWMutex }
 
+// Cond is sync.Cond wrapper
+type Cond struct{ sync.Cond }
+
+// NewCond returns a new Cond with Locker l.
+func NewCond(l sync.Locker) *Cond {
+	return &Cond{sync.Cond{L: l}}
+}
+
+// WaitGroup is sync.WaitGroup wrapper
+type WaitGroup struct{ sync.WaitGroup }
+
+// SetDetector does nothing when deadlock checking is disabled.
+func (wg *WaitGroup) SetDetector(d *Detector) {}
+
+// Go calls f in a new goroutine and adds that task to the WaitGroup.
+// When f returns, the task is removed from the WaitGroup.
+func (wg *WaitGroup) Go(f func()) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		f()
+	}()
+}
+
+// Blocking does nothing when deadlock checking is disabled.
+func Blocking() {}
+
+// Blocking does nothing when deadlock checking is disabled.
+func (d *Detector) Blocking() {}
+
+// SetName does nothing when deadlock checking is disabled.
+func (m *Mutex) SetName(name string) {}
+
+// SetLevel does nothing when deadlock checking is disabled.
+func (m *Mutex) SetLevel(level int) {}
+
+// SetClass does nothing when deadlock checking is disabled.
+func (m *Mutex) SetClass(c *LockClass) {}
+
+// SetDetector does nothing when deadlock checking is disabled.
+func (m *Mutex) SetDetector(d *Detector) {}
+
+// LockContext locks m like Lock, but returns ctx.Err()
+// without locking if ctx is done before m is available.
+func (m *Mutex) LockContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if tryLock(&m.Mutex) {
+		return nil
+	}
+	return waitLock(ctx, m.Mutex.Lock, m.Mutex.Unlock)
+}
+
+// SetName does nothing when deadlock checking is disabled.
+func (m *RWMutex) SetName(name string) {}
+
+// SetLevel does nothing when deadlock checking is disabled.
+func (m *RWMutex) SetLevel(level int) {}
+
+// SetClass does nothing when deadlock checking is disabled.
+func (m *RWMutex) SetClass(c *LockClass) {}
+
+// SetDetector does nothing when deadlock checking is disabled.
+func (m *RWMutex) SetDetector(d *Detector) {}
+
+// LockContext locks m for writing like Lock, but returns ctx.Err()
+// without locking if ctx is done before m is available.
+func (m *RWMutex) LockContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if tryRWLock(&m.RWMutex) {
+		return nil
+	}
+	return waitLock(ctx, m.RWMutex.Lock, m.RWMutex.Unlock)
+}
+
+// RLockContext locks m for reading like RLock, but returns ctx.Err()
+// without locking if ctx is done before m is available.
+func (m *RWMutex) RLockContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if tryRLock(&m.RWMutex) {
+		return nil
+	}
+	return waitLock(ctx, m.RWMutex.RLock, m.RWMutex.RUnlock)
+}
+
 // Enabled is true if deadlock checking is enabled
 const Enabled = false
@@ -0,0 +1,16 @@
+//go:build !go1.18 && (nodeadlock || (!deadlock && !race))
+// +build !go1.18
+// +build nodeadlock !deadlock,!race
+
+package deadlock
+
+import "sync"
+
+// tryLock returns false, as sync.Mutex has no TryLock before Go 1.18.
+func tryLock(mu *sync.Mutex) bool { return false }
+
+// tryRWLock returns false, as sync.RWMutex has no TryLock before Go 1.18.
+func tryRWLock(rw *sync.RWMutex) bool { return false }
+
+// tryRLock returns false, as sync.RWMutex has no TryRLock before Go 1.18.
+func tryRLock(rw *sync.RWMutex) bool { return false }
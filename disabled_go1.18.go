@@ -0,0 +1,16 @@
+//go:build go1.18 && (nodeadlock || (!deadlock && !race))
+// +build go1.18
+// +build nodeadlock !deadlock,!race
+
+package deadlock
+
+import "sync"
+
+// tryLock tries to lock mu without blocking.
+func tryLock(mu *sync.Mutex) bool { return mu.TryLock() }
+
+// tryRWLock tries to lock rw for writing without blocking.
+func tryRWLock(rw *sync.RWMutex) bool { return rw.TryLock() }
+
+// tryRLock tries to lock rw for reading without blocking.
+func tryRLock(rw *sync.RWMutex) bool { return rw.TryRLock() }
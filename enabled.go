@@ -4,11 +4,39 @@
 
 package deadlock
 
+import "sync"
+
 // Mutex is deadlock.DeadlockMutex wrapper
 type Mutex struct{ DeadlockMutex }
 
 // RWMutex is deadlock.DeadlockRWMutex wrapper
 type RWMutex struct{ DeadlockRWMutex }
 
+// Cond is deadlock.DeadlockCond wrapper
+type Cond struct{ DeadlockCond }
+
+// NewCond returns a new Cond with Locker l.
+func NewCond(l sync.Locker) *Cond {
+	return &Cond{DeadlockCond{L: l}}
+}
+
+// WaitGroup is deadlock.DeadlockWaitGroup wrapper
+type WaitGroup struct{ DeadlockWaitGroup }
+
+// Blocking reports if the calling goroutine holds any locks,
+// call it before blocking operations like channel sends or receives.
+//
+// Logs potential deadlocks to Opts.LogBuf,
+// calling Opts.OnPotentialDeadlock on each occasion.
+func Blocking() {
+	defaultDetector.checkBlocking(getGoid(), callers(1), nil, nil)
+}
+
+// Blocking reports if the calling goroutine holds any locks bound to d.
+// See Blocking.
+func (d *Detector) Blocking() {
+	d.checkBlocking(getGoid(), callers(1), nil, nil)
+}
+
 // Enabled is true if deadlock checking is enabled
 const Enabled = true
@@ -0,0 +1,244 @@
+package deadlock
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+type graphNode struct {
+	mtx   interface{}
+	label string
+	cycle bool
+}
+
+type graphEdge struct {
+	before, after *graphNode
+	stacks        *beforeAfterStack
+	cycle         bool
+}
+
+// graph returns a snapshot of the lock order graph, sorted by label.
+func (l *lockOrder) graph() (nodes []*graphNode, edges []*graphEdge) {
+	l.mu.RLock()
+	byMtx := map[interface{}]*graphNode{}
+	node := func(mtx interface{}) *graphNode {
+		n := byMtx[mtx]
+		if n == nil {
+			n = &graphNode{mtx: mtx}
+			byMtx[mtx] = n
+			nodes = append(nodes, n)
+		}
+		return n
+	}
+	for k, v := range l.order {
+		edges = append(edges, &graphEdge{before: node(k.beforeMtx), after: node(k.afterMtx), stacks: v})
+	}
+	l.mu.RUnlock()
+
+	for _, n := range nodes {
+		n.label = mutexLabel(mutexName(n.mtx), n.mtx)
+	}
+	markCycles(nodes, edges)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].label < nodes[j].label })
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].before != edges[j].before {
+			return edges[i].before.label < edges[j].before.label
+		}
+		return edges[i].after.label < edges[j].after.label
+	})
+	return
+}
+
+// markCycles marks the nodes and edges that are part of a cycle, by finding
+// the strongly connected components using Tarjan's algorithm.
+func markCycles(nodes []*graphNode, edges []*graphEdge) {
+	succ := map[*graphNode][]*graphNode{}
+	for _, e := range edges {
+		succ[e.before] = append(succ[e.before], e.after)
+	}
+	index := map[*graphNode]int{}
+	lowlink := map[*graphNode]int{}
+	onStack := map[*graphNode]bool{}
+	component := map[*graphNode]int{}
+	var stack []*graphNode
+	var strongConnect func(n *graphNode)
+	strongConnect = func(n *graphNode) {
+		index[n] = len(index)
+		lowlink[n] = index[n]
+		stack = append(stack, n)
+		onStack[n] = true
+		for _, m := range succ[n] {
+			if _, visited := index[m]; !visited {
+				strongConnect(m)
+				if lowlink[m] < lowlink[n] {
+					lowlink[n] = lowlink[m]
+				}
+			} else if onStack[m] && index[m] < lowlink[n] {
+				lowlink[n] = index[m]
+			}
+		}
+		if lowlink[n] == index[n] {
+			var members []*graphNode
+			for {
+				m := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[m] = false
+				component[m] = index[n]
+				members = append(members, m)
+				if m == n {
+					break
+				}
+			}
+			if len(members) > 1 {
+				for _, m := range members {
+					m.cycle = true
+				}
+			}
+		}
+	}
+	for _, n := range nodes {
+		if _, visited := index[n]; !visited {
+			strongConnect(n)
+		}
+	}
+	for _, e := range edges {
+		e.cycle = e.before.cycle && component[e.before] == component[e.after]
+	}
+}
+
+// lockSite returns the first frame of stack outside of this package's mutex methods.
+func lockSite(stack []uintptr) (site runtime.Frame) {
+	frames := stackFrames(stack)
+	for _, site = range frames {
+		if !strings.HasPrefix(site.Function, "github.com/linkdata/deadlock.") || strings.HasSuffix(site.File, "_test.go") {
+			break
+		}
+	}
+	return
+}
+
+func siteString(frame runtime.Frame) string {
+	return fmt.Sprintf("%s:%d", filepath.Base(frame.File), frame.Line)
+}
+
+type jsonGraphEdge struct {
+	Before      string      `json:"before"`
+	BeforeName  string      `json:"before_name,omitempty"`
+	After       string      `json:"after"`
+	AfterName   string      `json:"after_name,omitempty"`
+	Goroutine   int64       `json:"goroutine"`
+	BeforeStack []jsonFrame `json:"before_stack"`
+	AfterStack  []jsonFrame `json:"after_stack"`
+	Cycle       bool        `json:"cycle"`
+}
+
+func writeGraph(w io.Writer, format Format, nodes []*graphNode, edges []*graphEdge) error {
+	bw := bufio.NewWriter(w)
+	switch format {
+	case FormatDOT:
+		fmt.Fprintln(bw, "digraph deadlock {")
+		writeDOTGraph(bw, nodes, edges)
+		fmt.Fprintln(bw, "}")
+	case FormatJSON:
+		b, err := json.Marshal(struct {
+			Edges []jsonGraphEdge `json:"edges"`
+		}{toJSONGraphEdges(edges)})
+		if err != nil {
+			return err
+		}
+		_, _ = bw.Write(append(b, '\n'))
+	default:
+		writeTextGraph(bw, edges)
+	}
+	return bw.Flush()
+}
+
+// writeDOTGraph writes the nodes and edges as Graphviz statements,
+// returning the identifiers given to the nodes.
+func writeDOTGraph(w io.Writer, nodes []*graphNode, edges []*graphEdge) (ids map[*graphNode]string) {
+	ids = map[*graphNode]string{}
+	for i, n := range nodes {
+		ids[n] = fmt.Sprintf("n%d", i)
+		attrs := ""
+		if n.cycle {
+			attrs = ", color=red"
+		}
+		fmt.Fprintf(w, "\t%s [label=%q%s];\n", ids[n], n.label, attrs)
+	}
+	for _, e := range edges {
+		attrs := ""
+		if e.cycle {
+			attrs = ", color=red"
+		}
+		label := siteString(lockSite(e.stacks.beforeStack)) + "\n" + siteString(lockSite(e.stacks.afterStack))
+		fmt.Fprintf(w, "\t%s -> %s [label=%q%s];\n", ids[e.before], ids[e.after], label, attrs)
+	}
+	return
+}
+
+func toJSONGraphEdges(edges []*graphEdge) (jes []jsonGraphEdge) {
+	jes = []jsonGraphEdge{}
+	for _, e := range edges {
+		jes = append(jes, jsonGraphEdge{
+			Before:      fmt.Sprintf("%p", e.before.mtx),
+			BeforeName:  mutexName(e.before.mtx),
+			After:       fmt.Sprintf("%p", e.after.mtx),
+			AfterName:   mutexName(e.after.mtx),
+			Goroutine:   e.stacks.gid,
+			BeforeStack: toJSONFrames(stackFrames(e.stacks.beforeStack)),
+			AfterStack:  toJSONFrames(stackFrames(e.stacks.afterStack)),
+			Cycle:       e.cycle,
+		})
+	}
+	return
+}
+
+func writeTextGraph(w io.Writer, edges []*graphEdge) {
+	for _, e := range edges {
+		cycle := ""
+		if e.cycle {
+			cycle = " (cycle)"
+		}
+		fmt.Fprintf(w, "%s -> %s%s\n", e.before.label, e.after.label, cycle)
+		fmt.Fprintf(w, "  before at %s\n", siteString(lockSite(e.stacks.beforeStack)))
+		fmt.Fprintf(w, "  after at %s\n", siteString(lockSite(e.stacks.afterStack)))
+	}
+}
+
+// EvictedEdges returns the number of edges the default Detector has evicted from its lock order graph.
+// See Detector.EvictedEdges.
+func EvictedEdges() uint64 {
+	return defaultDetector.EvictedEdges()
+}
+
+// EvictedEdges returns the number of edges evicted from the lock order graph.
+// When the graph reaches Options.MaxMapSize edges, the least recently seen ones
+// are evicted, and inversions involving them are no longer detected.
+func (d *Detector) EvictedEdges() uint64 {
+	return atomic.LoadUint64(&d.lo.evicted)
+}
+
+// WriteLockGraph writes the lock order graph learned so far by the default Detector to w.
+// See Detector.WriteLockGraph.
+func WriteLockGraph(w io.Writer, format Format) error {
+	return defaultDetector.WriteLockGraph(w, format)
+}
+
+// WriteLockGraph writes the lock order graph learned so far to w.
+// Each edge means some goroutine held the first lock while taking the second,
+// and is labelled with where the locks were taken. Locks and edges that are
+// part of a cycle are highlighted.
+//
+// FormatDOT writes a Graphviz digraph, FormatJSON a single JSON document
+// and FormatText one line per edge.
+func (d *Detector) WriteLockGraph(w io.Writer, format Format) error {
+	nodes, edges := d.lo.graph()
+	return writeGraph(w, format, nodes, edges)
+}
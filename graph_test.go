@@ -0,0 +1,121 @@
+package deadlock
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestWriteLockGraph(t *testing.T) {
+	defer restore()()
+	Opts.WriteLocked(func() { Opts.DeadlockTimeout = 0 })
+	mu, reports := captureReports()
+
+	var a, b, c, d DeadlockMutex
+	a.SetName("graph-a")
+	b.SetName("graph-b")
+	c.SetName("graph-c")
+	d.SetName("graph-d")
+	for _, pair := range [][2]*DeadlockMutex{{&a, &b}, {&b, &c}, {&c, &a}, {&c, &d}} {
+		pair[0].Lock()
+		pair[1].Lock()
+		unlock(pair[1])
+		unlock(pair[0])
+	}
+	waitReports(t, mu, reports, 1)
+
+	var buf bytes.Buffer
+	if err := WriteLockGraph(&buf, FormatDOT); err != nil {
+		t.Fatal(err)
+	}
+	dot := buf.String()
+	if !strings.HasPrefix(dot, "digraph deadlock {\n") || !strings.HasSuffix(dot, "}\n") {
+		t.Error(dot)
+	}
+	for _, want := range []string{`[label="graph-a (0x`, `graph_test.go:`, `color=red`} {
+		if !strings.Contains(dot, want) {
+			t.Error("missing", want, "in", dot)
+		}
+	}
+
+	buf.Reset()
+	if err := WriteLockGraph(&buf, FormatText); err != nil {
+		t.Fatal(err)
+	}
+	text := buf.String()
+	for _, want := range []string{"graph-a (0x", "graph-b (0x", "(cycle)\n  before at graph_test.go:"} {
+		if !strings.Contains(text, want) {
+			t.Error("missing", want, "in", text)
+		}
+	}
+
+	buf.Reset()
+	if err := WriteLockGraph(&buf, FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	var graph struct {
+		Edges []jsonGraphEdge
+	}
+	if err := json.Unmarshal(buf.Bytes(), &graph); err != nil {
+		t.Fatal(err)
+	}
+	cycles := map[string]bool{}
+	for _, e := range graph.Edges {
+		if strings.HasPrefix(e.BeforeName, "graph-") {
+			cycles[e.BeforeName+"->"+e.AfterName] = e.Cycle
+		}
+	}
+	want := map[string]bool{
+		"graph-a->graph-b": true,
+		"graph-b->graph-c": true,
+		"graph-c->graph-a": true,
+		"graph-c->graph-d": false,
+	}
+	for k, v := range want {
+		if got, ok := cycles[k]; !ok || got != v {
+			t.Error(k, "expected cycle", v, "got", got, ok)
+		}
+	}
+}
+
+func TestEvictedEdges(t *testing.T) {
+	t.Parallel()
+	d, mu, reports := newTestDetector()
+	d.Opts.WriteLocked(func() { d.Opts.MaxMapSize = 16 })
+
+	var a, b DeadlockMutex
+	a.SetDetector(d)
+	b.SetDetector(d)
+	others := make([]DeadlockMutex, 100)
+	for i := range others {
+		others[i].SetDetector(d)
+		// keep a→b recently seen while other edges come and go
+		a.Lock()
+		b.Lock()
+		unlock(&b)
+		unlock(&a)
+		a.Lock()
+		others[i].Lock()
+		unlock(&others[i])
+		unlock(&a)
+	}
+	d.lo.mu.RLock()
+	n := len(d.lo.order)
+	d.lo.mu.RUnlock()
+	if n > 16 {
+		t.Error("expected at most 16 edges, got", n)
+	}
+	if evicted := d.EvictedEdges(); evicted < 100-16 {
+		t.Error("expected at least", 100-16, "evicted edges, got", evicted)
+	}
+
+	b.Lock()
+	a.Lock()
+	unlock(&a)
+	unlock(&b)
+	r := waitReports(t, mu, reports, 1)[0]
+	if r.Kind != InconsistentLocking {
+		t.Error(r.Kind)
+	}
+}
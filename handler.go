@@ -0,0 +1,212 @@
+package deadlock
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Handler returns an http.Handler showing the state of the default Detector.
+// See Detector.Handler.
+func Handler() http.Handler {
+	return defaultDetector.Handler()
+}
+
+// Handler returns an http.Handler showing the locks currently held and from where,
+// the goroutines currently waiting and for how long, and the lock order graph
+// learned so far. It can be mounted next to net/http/pprof:
+//
+//	http.Handle("/debug/deadlock", deadlock.Handler())
+//
+// The format query parameter selects "text" (the default), "json" or "dot",
+// the latter a Graphviz digraph of the lock order graph with the goroutines
+// holding and waiting for the locks added.
+//
+// Waiting goroutines are only tracked while Opts.DeadlockTimeout is non-zero,
+// or Opts.DetectWaitCycles is set.
+func (d *Detector) Handler() http.Handler {
+	return http.HandlerFunc(d.serveHTTP)
+}
+
+func (d *Detector) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var format Format
+	switch f := r.URL.Query().Get("format"); f {
+	case "", "text":
+		format = FormatText
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	case "json":
+		format = FormatJSON
+		w.Header().Set("Content-Type", "application/json")
+	case "dot":
+		format = FormatDOT
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+	default:
+		http.Error(w, fmt.Sprintf("deadlock: unknown format %q", f), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_ = d.writeState(w, format, time.Now())
+}
+
+// writeState writes the locks held, the goroutines waiting and the lock order graph to w.
+func (d *Detector) writeState(w io.Writer, format Format, now time.Time) error {
+	held := d.lo.collect(func(h *heldLock) bool { return true })
+	sort.SliceStable(held, func(i, j int) bool { return held[i].gid < held[j].gid })
+	waiting := d.waiting()
+	nodes, edges := d.lo.graph()
+
+	bw := bufio.NewWriter(w)
+	switch format {
+	case FormatDOT:
+		writeDOTState(bw, held, waiting, nodes, edges, now)
+	case FormatJSON:
+		if err := writeJSONState(bw, held, waiting, edges, now); err != nil {
+			return err
+		}
+	default:
+		writeTextState(bw, held, waiting, edges, now)
+	}
+	return bw.Flush()
+}
+
+// waiting returns a copy of the registered waiters, longest waiting first.
+func (d *Detector) waiting() (waiting []waiter) {
+	d.waiters.mu.Lock()
+	for _, w := range d.waiters.list {
+		waiting = append(waiting, *w)
+	}
+	d.waiters.mu.Unlock()
+	sort.Slice(waiting, func(i, j int) bool { return waiting[i].since.Before(waiting[j].since) })
+	return
+}
+
+// waitingFor describes what a waiter waits for.
+func (w *waiter) waitingFor() string {
+	switch w.kind {
+	case CondTimeout:
+		return "cond"
+	case WaitGroupTimeout:
+		return "wait group"
+	}
+	if w.read {
+		return "read lock"
+	}
+	return "lock"
+}
+
+func writeTextState(w io.Writer, held []heldLock, waiting []waiter, edges []*graphEdge, now time.Time) {
+	fmt.Fprintln(w, "Held locks:")
+	for _, h := range held {
+		rl := reportLock(h.gid, h.mtx, h.stack, h.read)
+		what := "lock"
+		if h.read {
+			what = "read lock"
+		}
+		fmt.Fprintf(w, "goroutine %v holds %s %s", h.gid, what, rl.label())
+		if !h.since.IsZero() {
+			fmt.Fprintf(w, " for %v", now.Sub(h.since))
+		}
+		fmt.Fprintln(w, ", taken at:")
+		printFrames(w, rl.Stack)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "Waiting goroutines:")
+	for i := range waiting {
+		wt := &waiting[i]
+		rl := reportLock(wt.gid, wt.mtx, wt.stack, wt.read)
+		fmt.Fprintf(w, "goroutine %v has been waiting for %s %s for %v, at:\n", wt.gid, wt.waitingFor(), rl.label(), now.Sub(wt.since))
+		printFrames(w, rl.Stack)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "Lock order graph:")
+	writeTextGraph(w, edges)
+}
+
+type jsonHeldLock struct {
+	jsonLock
+	HeldFor string `json:"held_for,omitempty"`
+}
+
+type jsonWaiter struct {
+	jsonLock
+	WaitingFor string `json:"waiting_for"`
+	Waited     string `json:"waited"`
+}
+
+func writeJSONState(w io.Writer, held []heldLock, waiting []waiter, edges []*graphEdge, now time.Time) error {
+	state := struct {
+		Held    []jsonHeldLock  `json:"held"`
+		Waiting []jsonWaiter    `json:"waiting"`
+		Edges   []jsonGraphEdge `json:"edges"`
+	}{
+		Held:    []jsonHeldLock{},
+		Waiting: []jsonWaiter{},
+		Edges:   toJSONGraphEdges(edges),
+	}
+	for _, h := range held {
+		jh := jsonHeldLock{jsonLock: toJSONLock(reportLock(h.gid, h.mtx, h.stack, h.read))}
+		if !h.since.IsZero() {
+			jh.HeldFor = now.Sub(h.since).String()
+		}
+		state.Held = append(state.Held, jh)
+	}
+	for i := range waiting {
+		wt := &waiting[i]
+		state.Waiting = append(state.Waiting, jsonWaiter{
+			jsonLock:   toJSONLock(reportLock(wt.gid, wt.mtx, wt.stack, wt.read)),
+			WaitingFor: wt.waitingFor(),
+			Waited:     now.Sub(wt.since).String(),
+		})
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+func writeDOTState(w io.Writer, held []heldLock, waiting []waiter, nodes []*graphNode, edges []*graphEdge, now time.Time) {
+	fmt.Fprintln(w, "digraph deadlock {")
+	ids := writeDOTGraph(w, nodes, edges)
+	byMtx := map[interface{}]string{}
+	for n, id := range ids {
+		byMtx[n.mtx] = id
+	}
+	// mtxID returns the node of mtx, or of its LockClass, adding one if it isn't in the graph.
+	mtxID := func(mtx interface{}) string {
+		key := heldOrderKey(mtx)
+		id, ok := byMtx[key]
+		if !ok {
+			id = fmt.Sprintf("n%d", len(byMtx))
+			byMtx[key] = id
+			fmt.Fprintf(w, "\t%s [label=%q];\n", id, mutexLabel(mutexName(key), key))
+		}
+		return id
+	}
+	goroutines := map[int64]bool{}
+	gID := func(gid int64) string {
+		id := fmt.Sprintf("g%d", gid)
+		if !goroutines[gid] {
+			goroutines[gid] = true
+			fmt.Fprintf(w, "\t%s [label=%q, shape=box];\n", id, fmt.Sprintf("goroutine %d", gid))
+		}
+		return id
+	}
+	for _, h := range held {
+		label := "held at " + siteString(lockSite(h.stack))
+		fmt.Fprintf(w, "\t%s -> %s [label=%q, color=blue];\n", mtxID(h.mtx), gID(h.gid), label)
+	}
+	for i := range waiting {
+		wt := &waiting[i]
+		label := fmt.Sprintf("waits %v\n%s", now.Sub(wt.since).Round(time.Millisecond), siteString(lockSite(wt.stack)))
+		fmt.Fprintf(w, "\t%s -> %s [label=%q, style=dashed];\n", gID(wt.gid), mtxID(wt.mtx), label)
+	}
+	fmt.Fprintln(w, "}")
+}
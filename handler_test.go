@@ -0,0 +1,101 @@
+package deadlock
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, h http.Handler, url string) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+	return rec.Code, rec.Body.String()
+}
+
+func TestHandler(t *testing.T) {
+	d, _, _ := newTestDetector()
+	d.Opts.WriteLocked(func() { d.Opts.DeadlockTimeout = time.Hour })
+	h := d.Handler()
+
+	var a, b DeadlockMutex
+	a.SetDetector(d)
+	b.SetDetector(d)
+	a.SetName("handler-a")
+	b.SetName("handler-b")
+	a.Lock()
+	b.Lock()
+	unlock(&b)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Lock()
+		unlock(&a)
+	}()
+	waitForWaiters(t, d, 1)
+
+	code, text := get(t, h, "/debug/deadlock")
+	if code != http.StatusOK {
+		t.Error(code)
+	}
+	for _, want := range []string{
+		"Held locks:\ngoroutine ", "holds lock handler-a (0x", "TestHandler()",
+		"Waiting goroutines:\ngoroutine ", "has been waiting for lock handler-a (0x",
+		"Lock order graph:\nhandler-a (0x", "before at handler_test.go:",
+	} {
+		if !strings.Contains(text, want) {
+			t.Error("missing", want, "in", text)
+		}
+	}
+
+	code, js := get(t, h, "/debug/deadlock?format=json")
+	var state struct {
+		Held []struct {
+			Goroutine int64
+			Name      string
+		}
+		Waiting []struct {
+			Goroutine  int64
+			Name       string
+			WaitingFor string `json:"waiting_for"`
+			Waited     string
+		}
+		Edges []struct {
+			BeforeName string `json:"before_name"`
+			AfterName  string `json:"after_name"`
+		}
+	}
+	if err := json.Unmarshal([]byte(js), &state); err != nil || code != http.StatusOK {
+		t.Fatal(code, err, js)
+	}
+	if len(state.Held) != 1 || state.Held[0].Name != "handler-a" || state.Held[0].Goroutine != getGoid() {
+		t.Error(state.Held)
+	}
+	if len(state.Waiting) != 1 || state.Waiting[0].Name != "handler-a" || state.Waiting[0].WaitingFor != "lock" ||
+		state.Waiting[0].Goroutine == getGoid() || state.Waiting[0].Waited == "" {
+		t.Error(state.Waiting)
+	}
+	if len(state.Edges) != 1 || state.Edges[0].BeforeName != "handler-a" || state.Edges[0].AfterName != "handler-b" {
+		t.Error(state.Edges)
+	}
+
+	code, dot := get(t, h, "/debug/deadlock?format=dot")
+	if code != http.StatusOK || !strings.HasPrefix(dot, "digraph deadlock {\n") || !strings.HasSuffix(dot, "}\n") {
+		t.Error(code, dot)
+	}
+	for _, want := range []string{`[label="handler-a (0x`, `shape=box`, `color=blue`, `style=dashed`} {
+		if !strings.Contains(dot, want) {
+			t.Error("missing", want, "in", dot)
+		}
+	}
+
+	if code, _ := get(t, h, "/debug/deadlock?format=xml"); code != http.StatusBadRequest {
+		t.Error(code)
+	}
+
+	unlock(&a)
+	<-done
+}
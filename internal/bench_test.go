@@ -36,3 +36,29 @@ func BenchmarkLockParallel(b *testing.B) {
 			}
 		})
 }
+
+func BenchmarkLockNestedParallel(b *testing.B) {
+	var inner deadlock.Mutex
+	b.RunParallel(
+		func(p *testing.PB) {
+			var mu deadlock.Mutex
+			for p.Next() {
+				mu.Lock()
+				inner.Lock()
+				unlock(&inner)
+				unlock(&mu)
+			}
+		})
+}
+
+func BenchmarkLockNestedChain(b *testing.B) {
+	var mus [32]deadlock.Mutex
+	for i := 0; i < b.N; i++ {
+		for j := range mus {
+			mus[j].Lock()
+		}
+		for j := len(mus) - 1; j >= 0; j-- {
+			unlock(&mus[j])
+		}
+	}
+}
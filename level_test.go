@@ -0,0 +1,70 @@
+package deadlock
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSetLevel(t *testing.T) {
+	defer restore()()
+	Opts.WriteLocked(func() { Opts.DeadlockTimeout = 0 })
+	mu, reports := captureReports()
+
+	var low, high DeadlockMutex
+	var rw DeadlockRWMutex
+	var none DeadlockMutex
+	low.SetLevel(10)
+	high.SetLevel(20)
+	rw.SetLevel(20)
+
+	// in order, and unranked locks are never reported
+	low.Lock()
+	none.Lock()
+	high.Lock()
+	unlock(&high)
+	unlock(&none)
+	unlock(&low)
+
+	// reported on the first occurrence, also for the same level
+	high.Lock()
+	rw.RLock()
+	runlock(&rw)
+	unlock(&high)
+
+	r := waitReports(t, mu, reports, 1)[0]
+	if r.Kind != LockLevel || r.Lock.Mutex != &rw || r.Lock.Level != 20 || !r.Lock.Read {
+		t.Error(r.Kind, r.Lock)
+	}
+	if len(r.Holders) != 1 || r.Holders[0].Mutex != &high || r.Holders[0].Level != 20 {
+		t.Error(r.Holders)
+	}
+	if s := r.String(); !strings.Contains(s, "at level 20") {
+		t.Error(s)
+	}
+	if b, err := json.Marshal(r); err != nil || !strings.Contains(string(b), `"level":20`) {
+		t.Error(string(b), err)
+	}
+}
+
+func TestSetLevel_NoLockOrder(t *testing.T) {
+	d, mu, reports := newTestDetector()
+	d.Opts.WriteLocked(func() { d.Opts.MaxMapSize = 0 })
+
+	var low, high DeadlockMutex
+	low.SetDetector(d)
+	high.SetDetector(d)
+	low.SetLevel(10)
+	high.SetLevel(20)
+
+	// levels are checked without lock order tracking
+	high.Lock()
+	low.Lock()
+	unlock(&low)
+	unlock(&high)
+
+	r := waitReports(t, mu, reports, 1)[0]
+	if r.Kind != LockLevel || r.Lock.Mutex != &low || len(r.Holders) != 1 || r.Holders[0].Mutex != &high {
+		t.Error(r.Kind, r.Lock, r.Holders)
+	}
+}
@@ -1,32 +1,112 @@
 package deadlock
 
 import (
+	"context"
 	"sync/atomic"
 	"time"
 )
 
-func lock(tryLockFn func() bool, lockFn func(), curMtx interface{}) bool {
+func lock(d *Detector, tryLockFn func() bool, lockFn func(), curMtx interface{}, read bool) bool {
 	gid := getGoid()
 	curStack := callers(2)
 
 	if lockFn != nil {
-		if ms := atomic.LoadInt32(&maxMapSize); ms > 0 {
-			lo.preLock(int(ms), gid, curStack, curMtx)
-		}
+		d.preLock(gid, curStack, curMtx, read)
 	}
 
+	start := d.statsStart()
+	contended := false
 	if tryLockFn == nil || !tryLockFn() {
 		if lockFn == nil {
 			return false
 		}
-		if to := atomic.LoadInt32(&deadlockTimeout); to > 0 {
-			ch := make(chan struct{})
-			defer close(ch)
-			go lo.timeoutFn(ch, time.Duration(to)*time.Millisecond, gid, curStack, curMtx)
+		contended = tryLockFn != nil
+		if w := d.startWait(LockTimeout, gid, curStack, curMtx, read); w != nil {
+			defer d.endWait(w)
 		}
 		lockFn()
 	}
 
-	lo.postLock(gid, curStack, curMtx)
+	d.lo.postLock(gid, curStack, curMtx, read, d.statsAcquired(start, curMtx, contended))
 	return true
 }
+
+// lockContext is like lock, but returns ctx.Err() without locking
+// if ctx is done before the lock is acquired.
+func lockContext(ctx context.Context, d *Detector, tryLockFn func() bool, lockFn, unlockFn func(), curMtx interface{}, read bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	gid := getGoid()
+	curStack := callers(2)
+
+	d.preLock(gid, curStack, curMtx, read)
+
+	start := d.statsStart()
+	contended := false
+	if tryLockFn == nil || !tryLockFn() {
+		contended = tryLockFn != nil
+		if w := d.startWait(LockTimeout, gid, curStack, curMtx, read); w != nil {
+			defer d.endWait(w)
+		}
+		if err := waitLock(ctx, lockFn, unlockFn); err != nil {
+			return err
+		}
+	}
+
+	d.lo.postLock(gid, curStack, curMtx, read, d.statsAcquired(start, curMtx, contended))
+	return nil
+}
+
+func (d *Detector) preLock(gid int64, curStack []uintptr, curMtx interface{}, read bool) {
+	d.lo.preLock(int(atomic.LoadInt32(&d.maxMapSize)), gid, curStack, curMtx, read)
+}
+
+// statsStart returns the current time if Opts.CollectStats is set.
+func (d *Detector) statsStart() (start time.Time) {
+	if atomic.LoadInt32(&d.collectStats) != 0 {
+		start = time.Now()
+	}
+	return
+}
+
+// statsAcquired records a lock acquisition that started waiting at start,
+// and returns the time the lock was acquired. Does nothing if start is zero.
+func (d *Detector) statsAcquired(start time.Time, curMtx interface{}, contended bool) (since time.Time) {
+	if !start.IsZero() {
+		since = time.Now()
+		d.stats.acquired(curMtx, contended, since.Sub(start))
+	}
+	return
+}
+
+// waitLock calls lockFn in a new goroutine and waits for it to return or ctx to be done.
+// If ctx is done first, the lock is released using unlockFn once acquired and ctx.Err() is returned.
+// If ctx can never be done, lockFn is simply called.
+func waitLock(ctx context.Context, lockFn, unlockFn func()) error {
+	if ctx.Done() == nil {
+		lockFn()
+		return nil
+	}
+	locked := make(chan struct{})
+	go func() {
+		lockFn()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		select {
+		case <-locked:
+			return nil
+		default:
+		}
+		go func() {
+			<-locked
+			unlockFn()
+		}()
+		return ctx.Err()
+	}
+}
@@ -2,24 +2,62 @@ package deadlock
 
 import (
 	"bytes"
-	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/petermattis/goid"
 )
 
-const header = "POTENTIAL DEADLOCK:"
+// lockSetShards is the number of shards the lock sets of goroutines are spread over.
+const lockSetShards = 64
+
+// maxFreeLockSets bounds the number of emptied lock sets kept for reuse in each shard.
+const maxFreeLockSets = 16
 
 type lockOrder struct {
-	mu    sync.Mutex                          // protects following
-	cur   map[interface{}]stackGID            // stacktraces + gids for the locks currently taken.
-	order map[beforeAfterMtx]beforeAfterStack // expected order of locks.
+	seen    uint64 // counts edges seen in order, to find the least recently seen.
+	evicted uint64 // number of edges evicted from order.
+
+	d    *Detector                   // the Detector this belongs to
+	sets [lockSetShards]lockSetShard // locks currently held, by goroutine.
+
+	mu    sync.RWMutex                             // protects following
+	order map[beforeAfterMtx]*beforeAfterStack     // expected order of locks, or their LockClass.
+	after map[interface{}]map[interface{}]struct{} // locks seen taken after a given lock, the edges of order.
+	added uint64                                   // number of edges added to order, to find if it changed.
+
+	classMu sync.Mutex            // protects following
+	classes map[string]*LockClass // classes by call site, if Opts.AutoLockClasses is set.
+
+	unlockMu    sync.Mutex                    // protects following
+	lastUnlock  map[interface{}]unlockedStack // where each lock was last unlocked, if Opts.CheckUnlock is set.
+	unlockCount uint64                        // counts unlocks recorded in lastUnlock, to find the least recent.
+}
+
+// lockSetShard holds the lock sets of the goroutines whose ids map to it,
+// so that goroutines rarely contend when locking and unlocking.
+type lockSetShard struct {
+	mu   sync.Mutex           // protects following
+	sets map[int64][]heldLock // locks held by each goroutine, in the order taken.
+	free [][]heldLock         // emptied lock sets kept for reuse.
+}
+
+type heldLock struct {
+	mtx interface{}
+	stackGID
+	seq int64 // when taken, from lockClock, to order the holders of a lock
 }
 
 type stackGID struct {
-	stack []uintptr
-	gid   int64
+	stack        []uintptr
+	gid          int64
+	read         bool
+	stats        bool          // whether the hold time is collected
+	holdReported bool          // whether held longer than maxHold has been reported
+	maxHold      time.Duration // Opts.MaxHoldTime when taken, zero if disabled
+	since        time.Time     // when the lock was taken, if collecting stats or maxHold is non-zero
 }
 
 type beforeAfterMtx struct {
@@ -28,138 +66,587 @@ type beforeAfterMtx struct {
 }
 
 type beforeAfterStack struct {
+	seen        uint64 // value of lockOrder.seen when last seen, updated atomically
+	inCycle     uint32 // non-zero once the edge has been reported as part of a cycle, accessed atomically
 	beforeStack []uintptr
 	afterStack  []uintptr
+	gid         int64
+	beforeMtx   interface{} // the mutexes locked, which differ from the keys for a LockClass
+	afterMtx    interface{}
 }
 
-var lo = newLockOrder()
+// orderEdge is an edge to add to the lock order graph.
+type orderEdge struct {
+	key        beforeAfterMtx
+	stacks     beforeAfterStack
+	beforeRead bool // whether the lock before is a read lock
+}
 
-func newLockOrder() (lo *lockOrder) {
+func newLockOrder(d *Detector) (lo *lockOrder) {
 	lo = &lockOrder{
-		cur:   map[interface{}]stackGID{},
-		order: map[beforeAfterMtx]beforeAfterStack{},
+		d:     d,
+		order: map[beforeAfterMtx]*beforeAfterStack{},
+		after: map[interface{}]map[interface{}]struct{}{},
+
+		classes:    map[string]*LockClass{},
+		lastUnlock: map[interface{}]unlockedStack{},
+	}
+	for i := range lo.sets {
+		lo.sets[i].sets = map[int64][]heldLock{}
 	}
 	return
 }
 
-func (l *lockOrder) postLock(gid int64, curStack []uintptr, curMtx interface{}) {
-	l.mu.Lock()
-	l.cur[curMtx] = stackGID{curStack, gid}
-	l.mu.Unlock()
+func (l *lockOrder) shard(gid int64) *lockSetShard {
+	return &l.sets[uint64(gid)%lockSetShards] //#nosec G115
+}
+
+// remove removes the i'th lock held by gid, and must be called with s.mu held.
+func (s *lockSetShard) remove(gid int64, held []heldLock, i int) {
+	copy(held[i:], held[i+1:])
+	held[len(held)-1] = heldLock{}
+	held = held[:len(held)-1]
+	if len(held) > 0 {
+		s.sets[gid] = held
+		return
+	}
+	delete(s.sets, gid)
+	if len(s.free) < maxFreeLockSets {
+		s.free = append(s.free, held)
+	}
+}
+
+// lockAll locks all shards, in order.
+func (l *lockOrder) lockAll() {
+	for i := range l.sets {
+		l.sets[i].mu.Lock()
+	}
 }
 
-func (l *lockOrder) preLock(maxMapSize int, gid int64, curStack []uintptr, curMtx interface{}) {
+func (l *lockOrder) unlockAll() {
+	for i := range l.sets {
+		l.sets[i].mu.Unlock()
+	}
+}
+
+// held returns a copy of the locks held by gid, in the order taken.
+func (l *lockOrder) held(gid int64) []heldLock {
+	s := l.shard(gid)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]heldLock(nil), s.sets[gid]...)
+}
+
+// holders returns the current holders of mtx, oldest first.
+func (l *lockOrder) holders(mtx interface{}) (holders []heldLock) {
+	return l.collect(func(h *heldLock) bool { return h.mtx == mtx })
+}
+
+// collect returns the held locks for which fn returns true, oldest first.
+func (l *lockOrder) collect(fn func(h *heldLock) bool) (found []heldLock) {
+	for i := range l.sets {
+		s := &l.sets[i]
+		s.mu.Lock()
+		for _, held := range s.sets {
+			for j := range held {
+				if fn(&held[j]) {
+					found = append(found, held[j])
+				}
+			}
+		}
+		s.mu.Unlock()
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].seq < found[j].seq })
+	return
+}
+
+// lockClockStart is the origin of lockClock.
+var lockClockStart = time.Now()
+
+// lockClock returns the nanoseconds since lockClockStart on the monotonic clock.
+// Ordering acquisitions by it, rather than by a shared counter, keeps cores
+// from contending for a single cache line on every lock.
+func lockClock() int64 {
+	return int64(time.Since(lockClockStart))
+}
+
+func (l *lockOrder) postLock(gid int64, curStack []uintptr, curMtx interface{}, read bool, since time.Time) {
+	holder := stackGID{stack: curStack, gid: gid, read: read, stats: !since.IsZero(), since: since}
+	if ht := atomic.LoadInt32(&l.d.maxHoldTime); ht > 0 {
+		holder.maxHold = time.Duration(ht) * time.Millisecond
+		if since.IsZero() {
+			holder.since = time.Now()
+		}
+		l.d.startHold(holder.maxHold)
+	}
+	seq := lockClock()
+	s := l.shard(gid)
+	s.mu.Lock()
+	held, ok := s.sets[gid]
+	if !ok && len(s.free) > 0 {
+		held = s.free[len(s.free)-1]
+		s.free = s.free[:len(s.free)-1]
+	}
+	s.sets[gid] = append(held, heldLock{mtx: curMtx, stackGID: holder, seq: seq})
+	s.mu.Unlock()
+}
+
+func (l *lockOrder) preLock(maxMapSize int, gid int64, curStack []uintptr, curMtx interface{}, read bool) {
+	auto := atomic.LoadInt32(&l.d.autoLockClasses) != 0
+	if auto {
+		// the class is assigned where the mutex is first locked
+		l.orderKey(curMtx, curStack, auto)
+	}
+	order := maxMapSize > 0
+	if !order && mutexLevel(curMtx) == 0 && heldOrderKey(curMtx) == curMtx {
+		return // without lock order tracking, only levels and classes are checked
+	}
+	s := l.shard(gid)
+	s.mu.Lock()
+	held := s.sets[gid]
+	if len(held) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	var addBuf [4]orderEdge
+	reports, adds, added := l.checkOrder(held, gid, curStack, curMtx, read, auto, order, addBuf[:0])
+	s.mu.Unlock()
+
+	if len(adds) > 0 {
+		reports = append(reports, l.addOrders(maxMapSize, adds, added, gid, curStack, read)...)
+	}
+
+	for _, r := range reports {
+		r.Others = l.otherLocked(curMtx)
+		l.d.Opts.report(r)
+	}
+}
+
+// addOrders adds the edges returned by checkOrder to the lock order graph. If other
+// goroutines added edges since checkOrder, which returned the count of edges added
+// then, the cycles those now close with adds are returned.
+func (l *lockOrder) addOrders(maxMapSize int, adds []orderEdge, added uint64, gid int64, curStack []uintptr, read bool) (reports []*Report) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-
-	// Reset the map to keep memory footprint bounded
+	if l.added != added {
+		reports = l.cycleReports(adds, gid, curStack, read, true)
+	}
+	// Evict the least recently seen edges to keep memory footprint bounded,
+	// in batches so that the cost of finding them is spread over many locks.
 	if len(l.order) >= maxMapSize {
-		// This gets optimized to calling runtime.mapclear()
-		for k := range l.order {
-			delete(l.order, k)
-		}
+		l.evict(len(l.order) - maxMapSize + 1 + maxMapSize/16)
 	}
+	for _, add := range adds {
+		l.addOrder(add.key, add.stacks)
+	}
+	return
+}
 
-	for otherMtx, otherStackGID := range l.cur {
+// checkOrder checks locking curMtx while holding the locks in held, returning
+// the problems found and the edges to add to the lock order graph, or to
+// keep checking for being part of a cycle. Unless order is set, only lock
+// levels and classes are checked.
+func (l *lockOrder) checkOrder(held []heldLock, gid int64, curStack []uintptr, curMtx interface{}, read, auto, order bool, adds []orderEdge) (reports []*Report, _ []orderEdge, added uint64) {
+	curKey := l.orderKey(curMtx, curStack, auto)
+	curLevel := mutexLevel(curMtx)
+
+	var stale uint64
+	if order {
+		stale = uint64(atomic.LoadInt32(&l.d.maxMapSize)) / 16
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		added = l.added
+	}
+
+	for i := len(held) - 1; i >= 0; i-- {
+		other := &held[i]
+		otherMtx := other.mtx
+		if findHeld(held[i+1:], otherMtx) {
+			continue // only check the most recent acquisition of each lock
+		}
 		if otherMtx == curMtx {
-			if otherStackGID.gid == gid {
-				fmt.Fprintln(&Opts, header, "Recursive locking:")
-				fmt.Fprintf(&Opts, "goroutine %d lock %p:\n", gid, otherMtx)
-				printStack(&Opts, curStack)
-				fmt.Fprintln(&Opts, "same goroutine previously locked it from:")
-				printStack(&Opts, otherStackGID.stack)
-				l.otherLocked(curMtx)
-				_ = Opts.Flush()
-				Opts.PotentialDeadlock()
+			if !order {
+				continue
+			}
+			r := &Report{
+				Kind:    RecursiveLocking,
+				Lock:    reportLock(gid, curMtx, curStack, read),
+				Holders: []ReportLock{reportLock(gid, otherMtx, other.stack, other.read)},
+			}
+			if read && other.read {
+				if l.d.Opts.allowRecursiveRLock() {
+					continue
+				}
+				r.Kind = RecursiveRLocking
+				r.Severity = SeverityWarning
 			}
+			reports = append(reports, r)
 			continue
 		}
-		if otherStackGID.gid != gid { // We want locks taken in the same goroutine only.
+		if curLevel > 0 && mutexLevel(otherMtx) >= curLevel {
+			reports = append(reports, &Report{
+				Kind:    LockLevel,
+				Lock:    reportLock(gid, curMtx, curStack, read),
+				Holders: []ReportLock{reportLock(gid, otherMtx, other.stack, other.read)},
+			})
+		}
+		otherKey := heldOrderKey(otherMtx)
+		if otherKey == curKey {
+			// different mutexes of the same LockClass
+			if !curKey.(*LockClass).Nested {
+				reports = append(reports, &Report{
+					Kind:    NestedLocking,
+					Lock:    reportLock(gid, curMtx, curStack, read),
+					Holders: []ReportLock{reportLock(gid, otherMtx, other.stack, other.read)},
+				})
+			}
 			continue
 		}
-		if otherStacks, ok := l.order[beforeAfterMtx{curMtx, otherMtx}]; ok {
-			fmt.Fprintln(&Opts, header, "Inconsistent locking:")
-			fmt.Fprintln(&Opts, "in one goroutine: happened before")
-			printStack(&Opts, otherStacks.beforeStack)
-			fmt.Fprintln(&Opts, "happened after")
-			printStack(&Opts, otherStacks.afterStack)
+		if !order {
+			continue
+		}
+		key := beforeAfterMtx{otherKey, curKey}
+		stacks := l.order[key]
+		if stacks != nil {
+			// Only refresh edges not seen since the last eviction batch worth of
+			// edges was added, so that hot edges don't write shared cache lines.
+			// They can't be evicted before, as the oldest edges are evicted first.
+			if seen := atomic.LoadUint64(&l.seen); seen-atomic.LoadUint64(&stacks.seen) > stale {
+				atomic.StoreUint64(&stacks.seen, atomic.AddUint64(&l.seen, 1))
+			}
+		}
+		// Only new edges, or those already in a cycle, need searching for
+		// cycles; addOrder leaves the known ones as they are.
+		if stacks == nil || atomic.LoadUint32(&stacks.inCycle) != 0 {
+			adds = append(adds, orderEdge{key: key, stacks: beforeAfterStack{
+				beforeStack: other.stack,
+				afterStack:  curStack,
+				gid:         gid,
+				beforeMtx:   otherMtx,
+				afterMtx:    curMtx,
+			}, beforeRead: other.read})
+		}
+	}
+	if len(adds) > 0 {
+		reports = append(reports, l.cycleReports(adds, gid, curStack, read, false)...)
+	}
+	return reports, adds, added
+}
 
-			fmt.Fprintln(&Opts, "in another goroutine: happened before")
-			printStack(&Opts, otherStackGID.stack)
-			fmt.Fprintln(&Opts, "happened after")
-			printStack(&Opts, curStack)
-			l.otherLocked(curMtx)
-			fmt.Fprintln(&Opts)
-			_ = Opts.Flush()
-			Opts.PotentialDeadlock()
+func findHeld(held []heldLock, mtx interface{}) bool {
+	for i := range held {
+		if held[i].mtx == mtx {
+			return true
 		}
+	}
+	return false
+}
 
-		l.order[beforeAfterMtx{otherMtx, curMtx}] = beforeAfterStack{otherStackGID.stack, curStack}
+// addOrder adds an edge to the lock order graph, and must be called with l.mu held.
+func (l *lockOrder) addOrder(key beforeAfterMtx, stacks beforeAfterStack) {
+	if prev := l.order[key]; prev != nil {
+		prev.seen = atomic.AddUint64(&l.seen, 1)
+		return
 	}
+	stacks.seen = atomic.AddUint64(&l.seen, 1)
+	l.order[key] = &stacks
+	l.added++
+	afterSet := l.after[key.beforeMtx]
+	if afterSet == nil {
+		afterSet = map[interface{}]struct{}{}
+		l.after[key.beforeMtx] = afterSet
+	}
+	afterSet[key.afterMtx] = struct{}{}
 }
 
-func (l *lockOrder) postUnlock(curMtx interface{}) {
-	l.mu.Lock()
-	delete(l.cur, curMtx)
-	l.mu.Unlock()
-}
-
-func (l *lockOrder) timeoutFn(ch <-chan struct{}, timeout time.Duration, gid int64, curStack []uintptr, curMtx interface{}) {
-	t := time.NewTimer(timeout)
-	defer t.Stop()
-	select {
-	case <-t.C:
-		fmt.Fprintln(&Opts, header)
-		fmt.Fprintf(&Opts, "goroutine %v have been trying to lock %p for more than %v:\n",
-			gid, curMtx, timeout)
-		printStack(&Opts, curStack)
-
-		curStacks := stacks()
-
-		func() {
-			lo.mu.Lock()
-			defer lo.mu.Unlock()
-			if prev, ok := lo.cur[curMtx]; ok {
-				fmt.Fprintf(&Opts, "goroutine %v previously locked it from:\n", prev.gid)
-				printStack(&Opts, prev.stack)
-				goroutineStackList := bytes.Split(curStacks, []byte("\n\n"))
-				for _, goroutineStack := range goroutineStackList {
-					if goid.ExtractGID(goroutineStack) == prev.gid {
-						fmt.Fprintf(&Opts, "goroutine %v current stack:\n", prev.gid)
-						_, _ = Opts.Write(goroutineStack)
-						fmt.Fprintln(&Opts)
-					}
-				}
+// evict removes the n least recently seen edges from order.
+func (l *lockOrder) evict(n int) {
+	seen := make([]uint64, 0, len(l.order))
+	for _, stacks := range l.order {
+		seen = append(seen, stacks.seen)
+	}
+	sort.Slice(seen, func(i, j int) bool { return seen[i] < seen[j] })
+	if n > len(seen) {
+		n = len(seen)
+	}
+	if n < 1 {
+		return
+	}
+	cutoff := seen[n-1]
+	for k, stacks := range l.order {
+		if stacks.seen <= cutoff {
+			delete(l.order, k)
+			afterSet := l.after[k.beforeMtx]
+			delete(afterSet, k.afterMtx)
+			if len(afterSet) == 0 {
+				delete(l.after, k.beforeMtx)
 			}
-			lo.otherLocked(curMtx)
-		}()
+			atomic.AddUint64(&l.evicted, 1)
+		}
+	}
+}
+
+// cycleReports returns the InconsistentLocking reports for the edges in adds,
+// all leading to the same lock, that close a lock order cycle, and marks the
+// edges of those cycles so that they are searched again when next seen.
+// With recheck, the edges already reported or since added to order are skipped.
+// Must be called with l.mu held.
+func (l *lockOrder) cycleReports(adds []orderEdge, gid int64, curStack []uintptr, read, recheck bool) (reports []*Report) {
+	curKey := adds[0].key.afterMtx
+	if len(l.after[curKey]) == 0 {
+		return nil
+	}
+	var buf [4]interface{}
+	var idxBuf [4]int
+	targets, idx := buf[:0], idxBuf[:0]
+	for i := range adds {
+		if recheck && (adds[i].stacks.inCycle != 0 || l.order[adds[i].key] != nil) {
+			continue
+		}
+		targets = append(targets, adds[i].key.beforeMtx)
+		idx = append(idx, i)
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	for i, path := range l.findPaths(curKey, targets) {
+		if path == nil {
+			continue
+		}
+		add := &adds[idx[i]]
+		add.stacks.inCycle = 1
+		var cycle []ReportEdge
+		for j := 1; j < len(path); j++ {
+			otherStacks := l.order[beforeAfterMtx{path[j-1], path[j]}]
+			atomic.StoreUint32(&otherStacks.inCycle, 1)
+			cycle = append(cycle, ReportEdge{
+				Before: reportLock(otherStacks.gid, otherStacks.beforeMtx, otherStacks.beforeStack, false),
+				After:  reportLock(otherStacks.gid, otherStacks.afterMtx, otherStacks.afterStack, false),
+			})
+		}
+		cycle = append(cycle, ReportEdge{
+			Before: reportLock(gid, add.stacks.beforeMtx, add.stacks.beforeStack, add.beforeRead),
+			After:  reportLock(gid, add.stacks.afterMtx, curStack, read),
+		})
+		reports = append(reports, &Report{
+			Kind:  InconsistentLocking,
+			Lock:  reportLock(gid, add.stacks.afterMtx, curStack, read),
+			Cycle: cycle,
+		})
+	}
+	return
+}
+
+// findPaths returns, for each of toMtxs, the shortest chain of locks fromMtx, ..., toMtx
+// where each lock has been seen taken before the next one, or nil if there is none.
+// Adding the edge toMtx -> fromMtx would then close a lock order cycle.
+// A single breadth-first search serves all of toMtxs.
+func (l *lockOrder) findPaths(fromMtx interface{}, toMtxs []interface{}) [][]interface{} {
+	if len(l.after[fromMtx]) == 0 {
+		return make([][]interface{}, len(toMtxs))
+	}
+	parent := map[interface{}]interface{}{fromMtx: nil}
+	queue := []interface{}{fromMtx}
+	remaining := len(toMtxs)
+	isTarget := func(mtx interface{}) bool {
+		for _, to := range toMtxs {
+			if to == mtx {
+				return true
+			}
+		}
+		return false
+	}
+	for len(queue) > 0 && remaining > 0 {
+		mtx := queue[0]
+		queue = queue[1:]
+		for next := range l.after[mtx] {
+			if _, seen := parent[next]; seen {
+				continue
+			}
+			parent[next] = mtx
+			if isTarget(next) {
+				remaining--
+			}
+			queue = append(queue, next)
+		}
+	}
+	paths := make([][]interface{}, len(toMtxs))
+	for i, to := range toMtxs {
+		if _, found := parent[to]; !found || to == fromMtx {
+			continue
+		}
+		var path []interface{}
+		for p := to; p != nil; p = parent[p] {
+			path = append(path, p)
+		}
+		for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+			path[i], path[j] = path[j], path[i]
+		}
+		paths[i] = path
+	}
+	return paths
+}
+
+// postUnlock removes the holder of curMtx, which is normally gid. A lock may be
+// released by another goroutine, so if gid holds none, the oldest holder is removed;
+// another goroutine may already have locked it again since.
+func (l *lockOrder) postUnlock(gid int64, curMtx interface{}) {
+	if l.release(gid, curMtx) {
+		return
+	}
+	l.releaseOldest(curMtx)
+}
+
+// release removes the most recent acquisition of curMtx by gid,
+// returning false if gid doesn't hold it.
+func (l *lockOrder) release(gid int64, curMtx interface{}) bool {
+	s := l.shard(gid)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	held := s.sets[gid]
+	for i := len(held) - 1; i >= 0; i-- {
+		if held[i].mtx == curMtx {
+			held[i].released(l.d, curMtx)
+			s.remove(gid, held, i)
+			return true
+		}
+	}
+	return false
+}
+
+// released records the hold time if collecting stats, and uncounts
+// the lock from those held with a maxHold.
+func (sg stackGID) released(d *Detector, curMtx interface{}) {
+	if sg.stats {
+		d.stats.released(curMtx, time.Since(sg.since))
+	}
+	if sg.maxHold > 0 {
+		d.endHold()
+	}
+}
+
+// postRUnlock removes one reader of curMtx. A read lock may be released
+// by another goroutine, so if gid holds none, the oldest reader is removed.
+func (l *lockOrder) postRUnlock(gid int64, curMtx interface{}) {
+	if l.release(gid, curMtx) {
+		return
+	}
+	l.releaseOldest(curMtx)
+}
 
-		if Opts.PrintAllCurrentGoroutinesEnabled() {
-			fmt.Fprintln(&Opts, "All current goroutines:")
-			_, _ = Opts.Write(curStacks)
+// releaseOldest removes the holder of curMtx that locked it first.
+func (l *lockOrder) releaseOldest(curMtx interface{}) {
+	l.lockAll()
+	defer l.unlockAll()
+	var oldest *lockSetShard
+	var oldestGID int64
+	var oldestIndex int
+	var oldestSeq int64
+	for i := range l.sets {
+		s := &l.sets[i]
+		for otherGID, held := range s.sets {
+			for j := range held {
+				if held[j].mtx == curMtx && (oldest == nil || held[j].seq < oldestSeq) {
+					oldest, oldestGID, oldestIndex, oldestSeq = s, otherGID, j, held[j].seq
+				}
+			}
 		}
+	}
+	if oldest != nil {
+		held := oldest.sets[oldestGID]
+		held[oldestIndex].released(l.d, curMtx)
+		oldest.remove(oldestGID, held, oldestIndex)
+	}
+}
+
+// reportTimeout reports w as having waited longer than w.timeout to lock w.mtx.
+func (l *lockOrder) reportTimeout(w *waiter) {
+	r := &Report{
+		Kind:    LockTimeout,
+		Lock:    reportLock(w.gid, w.mtx, w.stack, w.read),
+		Timeout: w.timeout,
+	}
+
+	curStacks := stacks()
+
+	for _, prev := range l.holders(w.mtx) {
+		holder := reportLock(prev.gid, w.mtx, prev.stack, prev.read)
+		holder.CurrentStack = goroutineStack(curStacks, prev.gid)
+		r.Holders = append(r.Holders, holder)
+	}
+	r.Others = l.otherLocked(w.mtx)
 
-		fmt.Fprintln(&Opts)
-		_ = Opts.Flush()
-		Opts.PotentialDeadlock()
-		<-ch
-	case <-ch:
+	if l.d.Opts.PrintAllCurrentGoroutinesEnabled() {
+		r.AllGoroutines = string(curStacks)
 	}
+
+	l.d.Opts.report(r)
 }
 
-func (l *lockOrder) otherLocked(curMtx interface{}) {
-	printedHeader := false
-	for otherMtx, otherStackGID := range l.cur {
-		if otherMtx != curMtx {
-			if !printedHeader {
-				printedHeader = true
-				fmt.Fprintln(&Opts, "Other goroutines holding locks:")
+// expiredHolds returns the locks held longer than their maxHold at now
+// that have not yet been reported, marking them as reported.
+func (l *lockOrder) expiredHolds(now time.Time) (expired []heldLock) {
+	for i := range l.sets {
+		s := &l.sets[i]
+		s.mu.Lock()
+		for _, held := range s.sets {
+			for j := range held {
+				h := &held[j]
+				if h.maxHold > 0 && !h.holdReported && now.Sub(h.since) >= h.maxHold {
+					h.holdReported = true
+					expired = append(expired, *h)
+				}
 			}
-			fmt.Fprintf(&Opts, "goroutine %v lock %p\n", otherStackGID.gid, otherMtx)
-			printStack(&Opts, otherStackGID.stack)
 		}
+		s.mu.Unlock()
+	}
+	return
+}
+
+// reportHoldTimeouts reports the locks in expired as held longer than their maxHold.
+func (l *lockOrder) reportHoldTimeouts(expired []heldLock) {
+	curStacks := stacks()
+	for _, h := range expired {
+		r := &Report{
+			Kind:    HoldTimeout,
+			Lock:    reportLock(h.gid, h.mtx, h.stack, h.read),
+			Timeout: h.maxHold,
+			Others:  l.otherLocked(h.mtx),
+		}
+		r.Lock.CurrentStack = goroutineStack(curStacks, h.gid)
+		if l.d.Opts.PrintAllCurrentGoroutinesEnabled() {
+			r.AllGoroutines = string(curStacks)
+		}
+		l.d.Opts.report(r)
 	}
-	if printedHeader {
-		fmt.Fprintln(&Opts)
+}
+
+// goroutineStack returns the stack of goroutine gid from a dump of all goroutine stacks.
+func goroutineStack(curStacks []byte, gid int64) string {
+	for _, stack := range bytes.Split(curStacks, []byte("\n\n")) {
+		if goid.ExtractGID(stack) == gid {
+			return string(stack)
+		}
+	}
+	return ""
+}
+
+// otherLocked returns the locks currently held on mutexes other than curMtx.
+func (l *lockOrder) otherLocked(curMtx interface{}) (others []ReportLock) {
+	for _, other := range l.collect(func(h *heldLock) bool { return h.mtx != curMtx }) {
+		others = append(others, reportLock(other.gid, other.mtx, other.stack, other.read))
+	}
+	return
+}
+
+func reportLock(gid int64, mtx interface{}, stack []uintptr, read bool) ReportLock {
+	rl := ReportLock{Goroutine: gid, Mutex: mtx, Read: read, Stack: stackFrames(stack)}
+	if mm := metaOf(mtx); mm != nil {
+		rl.Name = mm.name
+		rl.Level = mutexLevel(mtx)
+		if mm.class != nil {
+			rl.Class = mm.class.Name
+		}
 	}
+	return rl
 }
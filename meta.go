@@ -0,0 +1,142 @@
+package deadlock
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// mutexMeta holds the optional settings of a mutex.
+// It is never modified once stored in a lockMeta.
+type mutexMeta struct {
+	name  string
+	level int
+	class *LockClass
+	d     *Detector // nil for the default Detector
+}
+
+// lockMeta allows reading the settings of a mutex without locking.
+type lockMeta struct {
+	v atomic.Value
+}
+
+var metaLock sync.Mutex // serializes lockMeta updates
+
+type hasLockMeta interface {
+	lockMeta() *lockMeta
+}
+
+func (lm *lockMeta) load() *mutexMeta {
+	if mm, ok := lm.v.Load().(*mutexMeta); ok {
+		return mm
+	}
+	return nil
+}
+
+func (lm *lockMeta) update(fn func(mm *mutexMeta)) {
+	metaLock.Lock()
+	defer metaLock.Unlock()
+	var mm mutexMeta
+	if prev := lm.load(); prev != nil {
+		mm = *prev
+	}
+	fn(&mm)
+	lm.v.Store(&mm)
+}
+
+// detector returns the Detector the mutex is bound to.
+func (lm *lockMeta) detector() *Detector {
+	if mm := lm.load(); mm != nil && mm.d != nil {
+		return mm.d
+	}
+	return defaultDetector
+}
+
+func metaOf(mtx interface{}) *mutexMeta {
+	if h, ok := mtx.(hasLockMeta); ok {
+		return h.lockMeta().load()
+	}
+	return nil
+}
+
+func mutexName(mtx interface{}) string {
+	if c, ok := mtx.(*LockClass); ok {
+		return c.String()
+	}
+	if mm := metaOf(mtx); mm != nil {
+		return mm.name
+	}
+	return ""
+}
+
+func mutexLevel(mtx interface{}) int {
+	if mm := metaOf(mtx); mm != nil {
+		if mm.level == 0 && mm.class != nil {
+			return mm.class.Level
+		}
+		return mm.level
+	}
+	return 0
+}
+
+// mutexLabel returns how mtx is shown in reports.
+func mutexLabel(name string, mtx interface{}) string {
+	if name != "" {
+		return fmt.Sprintf("%s (%p)", name, mtx)
+	}
+	return fmt.Sprintf("%p", mtx)
+}
+
+func (m *DeadlockMutex) lockMeta() *lockMeta {
+	return &m.meta
+}
+
+// SetName sets the name used for m in reports.
+func (m *DeadlockMutex) SetName(name string) {
+	m.meta.update(func(mm *mutexMeta) { mm.name = name })
+}
+
+// SetLevel sets the level of m in the lock hierarchy. While holding a lock with
+// a non-zero level, only locks with a higher level may be taken. Zero, the default,
+// leaves m outside the hierarchy.
+func (m *DeadlockMutex) SetLevel(level int) {
+	m.meta.update(func(mm *mutexMeta) { mm.level = level })
+}
+
+// SetDetector binds m to d, nil meaning the default Detector.
+// It must not be called while m is locked.
+func (m *DeadlockMutex) SetDetector(d *Detector) {
+	m.meta.update(func(mm *mutexMeta) { mm.d = d })
+}
+
+func (m *DeadlockRWMutex) lockMeta() *lockMeta {
+	return &m.meta
+}
+
+// SetName sets the name used for m in reports.
+func (m *DeadlockRWMutex) SetName(name string) {
+	m.meta.update(func(mm *mutexMeta) { mm.name = name })
+}
+
+// SetLevel sets the level of m in the lock hierarchy. While holding a lock with
+// a non-zero level, only locks with a higher level may be taken. Zero, the default,
+// leaves m outside the hierarchy.
+func (m *DeadlockRWMutex) SetLevel(level int) {
+	m.meta.update(func(mm *mutexMeta) { mm.level = level })
+}
+
+// SetDetector binds m to d, nil meaning the default Detector.
+// It must not be called while m is locked.
+func (m *DeadlockRWMutex) SetDetector(d *Detector) {
+	m.meta.update(func(mm *mutexMeta) { mm.d = d })
+}
+
+func (wg *DeadlockWaitGroup) lockMeta() *lockMeta {
+	return &wg.meta
+}
+
+// SetDetector binds wg to d, nil meaning the default Detector.
+// It must not be called while wg is in use.
+func (wg *DeadlockWaitGroup) SetDetector(d *Detector) {
+	wg.meta.update(func(mm *mutexMeta) { mm.d = d })
+}
@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"io"
 	"os"
-	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -13,51 +12,130 @@ type Options struct {
 	// Waiting for a lock for longer than a non-zero DeadlockTimeout milliseconds is considered a deadlock.
 	// Set to 30 seconds by default.
 	DeadlockTimeout time.Duration
+	// How often waiting goroutines are checked against DeadlockTimeout, and for wait cycles,
+	// and held locks against MaxHoldTime. Zero means a tenth of the timeout checked against,
+	// or 100 milliseconds if DeadlockTimeout is zero.
+	WatchdogInterval time.Duration
+	// If set, goroutines waiting to lock mutexes held by each other, or for a writer queued
+	// to lock a RWMutex, are reported as a deadlock once seen in two consecutive checks,
+	// without waiting for DeadlockTimeout. See WaitCycle.
+	DetectWaitCycles bool
+	// Holding a lock for longer than a non-zero MaxHoldTime milliseconds is reported,
+	// up to WatchdogInterval late. Disabled by default.
+	MaxHoldTime time.Duration
 	// OnPotentialDeadlock is called each time a potential deadlock is detected -- either based on
-	// lock order or on lock wait time. If nil, panics instead.
+	// lock order or on lock wait time. If both it and OnReport are nil, panics instead.
 	OnPotentialDeadlock func()
+	// OnReport is called with a description of each potential deadlock detected,
+	// before OnPotentialDeadlock. If both it and OnPotentialDeadlock are nil, panics instead.
+	// Reports with SeverityWarning are only passed to OnReport.
+	OnReport func(r *Report)
+	// If set, a goroutine read locking a mutex it already holds a read lock on is not reported.
+	// Otherwise it is reported as a warning, since it deadlocks only if a writer is waiting.
+	AllowRecursiveRLock bool
+	// If set, starting to wait on a Cond or WaitGroup, or calling Blocking(), while holding
+	// locks is reported as a warning instead of as a potential deadlock.
+	WarnBlockingWhileLocked bool
+	// If set, unlocking a mutex that isn't locked is reported before the unlock
+	// crashes the program, together with where it was last unlocked (remembered for
+	// the 4096 most recently unlocked mutexes, regardless of MaxMapSize).
+	CheckUnlock bool
+	// If set, unlocking a mutex locked by another goroutine is reported as a warning.
+	WarnForeignUnlock bool
+	// If set, each mutex without a LockClass is put in one for the call site that first
+	// locks it, so that all mutexes first locked there are ordered as one. See LockClass.
+	AutoLockClasses bool
 	// Sets the maximum size of the map that tracks lock ordering.
-	// Setting this to zero disables tracking of lock order. Default is a reasonable size.
+	// Setting this to zero disables tracking of lock order, and with it inconsistent and
+	// recursive locking detection, but not lock levels or classes. Default is a reasonable size.
 	MaxMapSize int
 	// Will dump stacktraces of all goroutines when inconsistent locking is detected.
 	PrintAllCurrentGoroutines bool
 	// Where to write reports, set to os.Stderr by default.
 	LogBuf io.Writer
+	// How reports are written to LogBuf, FormatText by default.
+	ReportFormat Format
+	// InconsistentLocking reports matching any of these are not reported.
+	// See ParseSuppressions and LoadSuppressions.
+	Suppressions []Suppression
+	// If set, a report with the same kind and lock call sites as an earlier one is
+	// only reported once, or once per ReportInterval if that is non-zero.
+	// Changing either forgets the reports seen so far.
+	DedupReports bool
+	// With DedupReports, how often the same problem may be reported again.
+	// Report.Count then holds the number of times it occurred since it was last reported.
+	ReportInterval time.Duration
+	// If set, lock contention, wait and hold times are collected for each mutex. See Stats().
+	CollectStats bool
 }
 
-var optsLock sync.RWMutex
-var maxMapSize int32 = 1024 * 64
-var deadlockTimeout int32 = 30 * 1000
-
-// Opts control how deadlock detection behaves.
+// Opts control how the default Detector behaves.
 // To safely read or change options during runtime, use Opts.ReadLocked() and Opts.WriteLocked()
 var Opts = Options{
-	DeadlockTimeout: time.Millisecond * time.Duration(deadlockTimeout),
-	MaxMapSize:      int(maxMapSize),
+	DeadlockTimeout: defaultDeadlockTimeout,
+	MaxMapSize:      defaultMaxMapSize,
 	LogBuf:          os.Stderr,
 }
 
+// detector returns the Detector opts are the Options of, or the default
+// Detector if they are not those of any.
+func (opts *Options) detector() *Detector {
+	if d, ok := detectors.Load(opts); ok {
+		return d.(*Detector)
+	}
+	return defaultDetector
+}
+
 // WriteLocked calls the given function with Opts locked for writing.
 func (opts *Options) WriteLocked(fn func()) {
-	optsLock.Lock()
-	defer optsLock.Unlock()
+	d := opts.detector()
+	d.optsLock.Lock()
+	defer d.optsLock.Unlock()
+	dedup, interval := opts.DedupReports, opts.ReportInterval
 	fn()
-	atomic.StoreInt32(&maxMapSize, int32(opts.MaxMapSize))                                                 //#nosec G115
-	atomic.StoreInt32(&deadlockTimeout, int32(opts.DeadlockTimeout.Nanoseconds()/int64(time.Millisecond))) //#nosec G115
+	if opts.DedupReports != dedup || opts.ReportInterval != interval {
+		// forget the reports seen under the previous settings
+		d.reports.reset()
+	}
+	d.load()
+}
+
+// load updates the copies of d.Opts that are read without locking.
+// Must be called with d.optsLock held for writing, or before d is in use.
+func (d *Detector) load() {
+	opts := d.Opts
+	atomic.StoreInt32(&d.maxMapSize, int32(opts.MaxMapSize))                                                   //#nosec G115
+	atomic.StoreInt32(&d.deadlockTimeout, int32(opts.DeadlockTimeout.Nanoseconds()/int64(time.Millisecond)))   //#nosec G115
+	atomic.StoreInt32(&d.watchdogInterval, int32(opts.WatchdogInterval.Nanoseconds()/int64(time.Millisecond))) //#nosec G115
+	atomic.StoreInt32(&d.maxHoldTime, int32(opts.MaxHoldTime.Nanoseconds()/int64(time.Millisecond)))           //#nosec G115
+	atomic.StoreInt32(&d.collectStats, boolToInt32(opts.CollectStats))
+	atomic.StoreInt32(&d.checkUnlock, boolToInt32(opts.CheckUnlock))
+	atomic.StoreInt32(&d.warnForeignUnlock, boolToInt32(opts.WarnForeignUnlock))
+	atomic.StoreInt32(&d.autoLockClasses, boolToInt32(opts.AutoLockClasses))
+	atomic.StoreInt32(&d.detectWaitCycles, boolToInt32(opts.DetectWaitCycles))
+}
+
+func boolToInt32(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
 }
 
 // ReadLocked calls the given function with Opts locked for reading.
 func (opts *Options) ReadLocked(fn func()) {
-	optsLock.RLock()
-	defer optsLock.RUnlock()
+	d := opts.detector()
+	d.optsLock.RLock()
+	defer d.optsLock.RUnlock()
 	fn()
 }
 
 // Write implements io.Writer for Options.
 func (opts *Options) Write(b []byte) (int, error) {
-	optsLock.RLock()
+	d := opts.detector()
+	d.optsLock.RLock()
 	logBuf := opts.LogBuf
-	optsLock.RUnlock()
+	d.optsLock.RUnlock()
 	if logBuf != nil {
 		return logBuf.Write(b)
 	}
@@ -66,9 +144,10 @@ func (opts *Options) Write(b []byte) (int, error) {
 
 // Flush will flush the LogBuf if it is a *bufio.Writer
 func (opts *Options) Flush() error {
-	optsLock.RLock()
+	d := opts.detector()
+	d.optsLock.RLock()
 	logBuf := opts.LogBuf
-	optsLock.RUnlock()
+	d.optsLock.RUnlock()
 	if logBuf != nil {
 		if buf, ok := logBuf.(*bufio.Writer); ok {
 			return buf.Flush()
@@ -79,17 +158,69 @@ func (opts *Options) Flush() error {
 
 // PotentialDeadlock calls OnPotentialDeadlock if it is set, or panics if not.
 func (opts *Options) PotentialDeadlock() {
-	optsLock.RLock()
+	d := opts.detector()
+	d.optsLock.RLock()
 	onPotentialDeadlock := opts.OnPotentialDeadlock
-	optsLock.RUnlock()
+	d.optsLock.RUnlock()
 	if onPotentialDeadlock == nil {
 		panic("deadlock detected")
 	}
 	onPotentialDeadlock()
 }
 
+// report writes r to LogBuf and then calls OnReport and, unless r is a warning,
+// OnPotentialDeadlock. Panics if neither are set. Does nothing if r is suppressed,
+// or is a duplicate and DedupReports is set.
+func (opts *Options) report(r *Report) {
+	d := opts.detector()
+	d.optsLock.RLock()
+	format := opts.ReportFormat
+	sups := opts.Suppressions
+	dedup := opts.DedupReports
+	interval := opts.ReportInterval
+	d.optsLock.RUnlock()
+	if r.suppressed(sups) {
+		return
+	}
+	r.Count = 1
+	if dedup && d.reports.seenBefore(r, interval) {
+		return
+	}
+	r.write(opts, format)
+	_ = opts.Flush()
+	d.optsLock.RLock()
+	onReport := opts.OnReport
+	onPotentialDeadlock := opts.OnPotentialDeadlock
+	d.optsLock.RUnlock()
+	if onReport != nil {
+		onReport(r)
+		if onPotentialDeadlock == nil {
+			return
+		}
+	}
+	if r.Severity == SeverityWarning {
+		return
+	}
+	opts.PotentialDeadlock()
+}
+
+func (opts *Options) allowRecursiveRLock() bool {
+	d := opts.detector()
+	d.optsLock.RLock()
+	defer d.optsLock.RUnlock()
+	return opts.AllowRecursiveRLock
+}
+
+func (opts *Options) warnBlockingWhileLocked() bool {
+	d := opts.detector()
+	d.optsLock.RLock()
+	defer d.optsLock.RUnlock()
+	return opts.WarnBlockingWhileLocked
+}
+
 func (opts *Options) PrintAllCurrentGoroutinesEnabled() bool {
-	optsLock.RLock()
-	defer optsLock.RUnlock()
+	d := opts.detector()
+	d.optsLock.RLock()
+	defer d.optsLock.RUnlock()
 	return opts.PrintAllCurrentGoroutines
 }
@@ -0,0 +1,443 @@
+package deadlock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+)
+
+const header = "POTENTIAL DEADLOCK:"
+
+// Format selects how reports are written.
+type Format int
+
+const (
+	// FormatText is human-readable text in the style of the race detector.
+	FormatText Format = iota
+	// FormatJSON is a single JSON document per report.
+	FormatJSON
+	// FormatDOT is a Graphviz graph, only supported by WriteLockGraph.
+	FormatDOT
+)
+
+// ReportKind identifies the kind of potential deadlock a Report describes.
+type ReportKind int
+
+const (
+	// RecursiveLocking means a goroutine tried to lock a mutex it already holds.
+	RecursiveLocking ReportKind = iota + 1
+	// InconsistentLocking means the order in which locks were taken forms a cycle.
+	InconsistentLocking
+	// LockTimeout means a goroutine waited longer than Options.DeadlockTimeout for a lock.
+	LockTimeout
+	// RecursiveRLocking means a goroutine tried to read lock a mutex it already holds
+	// a read lock on. This only deadlocks if another goroutine is waiting to write lock it.
+	RecursiveRLocking
+	// HoldTimeout means a goroutine held a lock for longer than Options.MaxHoldTime.
+	HoldTimeout
+	// CondTimeout means a goroutine waited longer than Options.DeadlockTimeout on a Cond.
+	CondTimeout
+	// WaitGroupTimeout means a goroutine waited longer than Options.DeadlockTimeout on a WaitGroup.
+	WaitGroupTimeout
+	// BlockingWhileLocked means a goroutine started waiting on a Cond or WaitGroup, or called
+	// Blocking(), while holding locks.
+	BlockingWhileLocked
+	// UnlockOfUnlocked means a mutex that isn't locked was about to be unlocked.
+	// Only reported if Options.CheckUnlock is set.
+	UnlockOfUnlocked
+	// ForeignUnlock means a goroutine was about to unlock a mutex locked by another goroutine.
+	// Only reported if Options.WarnForeignUnlock is set.
+	ForeignUnlock
+	// LockLevel means a goroutine locked a mutex while holding one with the same or
+	// a higher level. See DeadlockMutex.SetLevel.
+	LockLevel
+	// NestedLocking means a goroutine locked a mutex while holding another of the
+	// same LockClass, which is not Nested.
+	NestedLocking
+	// WaitCycle means goroutines are waiting to lock mutexes held by each other,
+	// which is a deadlock. Only reported if Options.DetectWaitCycles is set.
+	WaitCycle
+)
+
+// Severity tells how a Report is handled.
+type Severity int
+
+const (
+	// SeverityError reports are passed to Options.OnPotentialDeadlock, which panics by default.
+	SeverityError Severity = iota
+	// SeverityWarning reports are only written to Options.LogBuf and passed to Options.OnReport.
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+func (k ReportKind) String() string {
+	switch k {
+	case RecursiveLocking:
+		return "recursive locking"
+	case InconsistentLocking:
+		return "inconsistent locking"
+	case LockTimeout:
+		return "lock timeout"
+	case RecursiveRLocking:
+		return "recursive read locking"
+	case HoldTimeout:
+		return "hold timeout"
+	case CondTimeout:
+		return "cond timeout"
+	case WaitGroupTimeout:
+		return "wait group timeout"
+	case BlockingWhileLocked:
+		return "blocking while locked"
+	case UnlockOfUnlocked:
+		return "unlock of unlocked"
+	case ForeignUnlock:
+		return "foreign unlock"
+	case LockLevel:
+		return "lock level violation"
+	case NestedLocking:
+		return "nested locking"
+	case WaitCycle:
+		return "wait cycle"
+	}
+	return fmt.Sprintf("ReportKind(%d)", int(k))
+}
+
+// ReportLock describes a lock taken or requested by a goroutine.
+type ReportLock struct {
+	Goroutine    int64           // goroutine ID
+	Mutex        interface{}     // the mutex
+	Name         string          // name of the mutex, if set with SetName
+	Read         bool            // true if this is a read lock
+	Level        int             // level of the mutex, if set with SetLevel or by its LockClass
+	Class        string          // name of the LockClass of the mutex, if any
+	Count        int             // outstanding count of a WaitGroup Add call site
+	Stack        []runtime.Frame // where the goroutine locked or tried to lock Mutex
+	CurrentStack string          // current stack of the goroutine, if known
+}
+
+func (rl ReportLock) label() string {
+	return mutexLabel(rl.Name, rl.Mutex)
+}
+
+// ReportEdge is one edge of a lock order cycle.
+// Some goroutine held Before.Mutex while it locked After.Mutex.
+type ReportEdge struct {
+	Before ReportLock
+	After  ReportLock
+}
+
+// Report describes a potential deadlock.
+type Report struct {
+	Kind     ReportKind
+	Severity Severity
+	// Lock is the lock being acquired when the potential deadlock was detected,
+	// the lock held for too long for HoldTimeout, or the Cond or WaitGroup waited on.
+	// For WaitCycle, it is the lock waited for by the first goroutine in Cycle.
+	// For BlockingWhileLocked, Lock.Mutex is nil if Blocking() was called.
+	Lock ReportLock
+	// Timeout is the DeadlockTimeout that was exceeded for LockTimeout, CondTimeout and
+	// WaitGroupTimeout, or the MaxHoldTime that was exceeded for HoldTimeout.
+	Timeout time.Duration
+	// Holders are the previous acquisitions of Lock.Mutex; by the same goroutine
+	// for RecursiveLocking and RecursiveRLocking, or by the goroutines being
+	// waited on for LockTimeout. For CondTimeout, it is where the Cond was last
+	// signalled, if ever. For WaitGroupTimeout, it is the Add call sites with
+	// an outstanding count. For BlockingWhileLocked, it is the locks held.
+	// For UnlockOfUnlocked, it is the last unlock, if known. For ForeignUnlock,
+	// it is the goroutines holding the lock. For LockLevel, it is the lock held
+	// with the same or a higher level. For NestedLocking, it is the lock held of
+	// the same class.
+	Holders []ReportLock
+	// Cycle lists the edges of the lock order cycle for InconsistentLocking.
+	// The last edge is the one that closed the cycle.
+	// For WaitCycle, each edge is a goroutine in the cycle, which holds Before
+	// while waiting for After, which is held by the next goroutine. Before has
+	// no Stack if the goroutine instead is a writer waiting to lock the RWMutex
+	// ahead of the previous goroutine, which waits to read lock it.
+	Cycle []ReportEdge
+	// Others are the locks on other mutexes held at the time.
+	Others []ReportLock
+	// AllGoroutines holds the stacks of all goroutines if Options.PrintAllCurrentGoroutines is set.
+	AllGoroutines string
+	// Count is the number of times the problem occurred since it was last reported,
+	// which is more than one only if Options.DedupReports and Options.ReportInterval are set.
+	Count int
+}
+
+// String returns the report in the same human-readable form that is written to Options.LogBuf.
+func (r *Report) String() string {
+	var buf bytes.Buffer
+	r.writeText(&buf)
+	return buf.String()
+}
+
+func (r *Report) writeText(w io.Writer) {
+	switch r.Kind {
+	case RecursiveLocking:
+		fmt.Fprintln(w, header, "Recursive locking:")
+		fmt.Fprintf(w, "goroutine %d lock %s:\n", r.Lock.Goroutine, r.Lock.label())
+		printFrames(w, r.Lock.Stack)
+		for _, holder := range r.Holders {
+			fmt.Fprintln(w, "same goroutine previously locked it from:")
+			printFrames(w, holder.Stack)
+		}
+	case RecursiveRLocking:
+		fmt.Fprintln(w, header, "Recursive read locking (deadlocks if a writer is waiting):")
+		fmt.Fprintf(w, "goroutine %d read lock %s:\n", r.Lock.Goroutine, r.Lock.label())
+		printFrames(w, r.Lock.Stack)
+		for _, holder := range r.Holders {
+			fmt.Fprintln(w, "same goroutine previously read locked it from:")
+			printFrames(w, holder.Stack)
+		}
+	case InconsistentLocking:
+		fmt.Fprintln(w, header, "Inconsistent locking:")
+		for i, edge := range r.Cycle {
+			who := "another"
+			if i == 0 {
+				who = "one"
+			}
+			fmt.Fprintf(w, "in %s goroutine: lock %s happened before\n", who, edge.Before.label())
+			printFrames(w, edge.Before.Stack)
+			fmt.Fprintf(w, "lock %s happened after\n", edge.After.label())
+			printFrames(w, edge.After.Stack)
+		}
+	case LockTimeout:
+		fmt.Fprintln(w, header)
+		fmt.Fprintf(w, "goroutine %v have been trying to lock %s for more than %v:\n",
+			r.Lock.Goroutine, r.Lock.label(), r.Timeout)
+		printFrames(w, r.Lock.Stack)
+		for _, holder := range r.Holders {
+			fmt.Fprintf(w, "goroutine %v previously locked it from:\n", holder.Goroutine)
+			printFrames(w, holder.Stack)
+			if holder.CurrentStack != "" {
+				fmt.Fprintf(w, "goroutine %v current stack:\n", holder.Goroutine)
+				fmt.Fprintln(w, holder.CurrentStack)
+			}
+		}
+	case HoldTimeout:
+		fmt.Fprintln(w, header)
+		fmt.Fprintf(w, "goroutine %v have been holding lock %s for more than %v, locked it from:\n",
+			r.Lock.Goroutine, r.Lock.label(), r.Timeout)
+		printFrames(w, r.Lock.Stack)
+		if r.Lock.CurrentStack != "" {
+			fmt.Fprintf(w, "goroutine %v current stack:\n", r.Lock.Goroutine)
+			fmt.Fprintln(w, r.Lock.CurrentStack)
+		}
+	case CondTimeout:
+		fmt.Fprintln(w, header)
+		fmt.Fprintf(w, "goroutine %v have been waiting on cond %s for more than %v:\n",
+			r.Lock.Goroutine, r.Lock.label(), r.Timeout)
+		printFrames(w, r.Lock.Stack)
+		if len(r.Holders) == 0 {
+			fmt.Fprintln(w, "cond has not been signalled")
+			fmt.Fprintln(w)
+		}
+		for _, holder := range r.Holders {
+			fmt.Fprintf(w, "goroutine %v last signalled it from:\n", holder.Goroutine)
+			printFrames(w, holder.Stack)
+		}
+	case WaitGroupTimeout:
+		fmt.Fprintln(w, header)
+		fmt.Fprintf(w, "goroutine %v have been waiting on wait group %s for more than %v:\n",
+			r.Lock.Goroutine, r.Lock.label(), r.Timeout)
+		printFrames(w, r.Lock.Stack)
+		for _, holder := range r.Holders {
+			fmt.Fprintf(w, "goroutine %v added %d still outstanding from:\n", holder.Goroutine, holder.Count)
+			printFrames(w, holder.Stack)
+		}
+	case BlockingWhileLocked:
+		fmt.Fprintln(w, header, "Blocking while holding locks:")
+		if r.Lock.Mutex != nil {
+			fmt.Fprintf(w, "goroutine %v is about to wait on %s:\n", r.Lock.Goroutine, r.Lock.label())
+		} else {
+			fmt.Fprintf(w, "goroutine %v is about to block:\n", r.Lock.Goroutine)
+		}
+		printFrames(w, r.Lock.Stack)
+		for _, holder := range r.Holders {
+			fmt.Fprintf(w, "while holding lock %s taken at:\n", holder.label())
+			printFrames(w, holder.Stack)
+		}
+	case UnlockOfUnlocked:
+		fmt.Fprintln(w, header, "Unlock of unlocked mutex:")
+		fmt.Fprintf(w, "goroutine %v unlock %s:\n", r.Lock.Goroutine, r.Lock.label())
+		printFrames(w, r.Lock.Stack)
+		if len(r.Holders) == 0 {
+			fmt.Fprintln(w, "no previous unlock recorded")
+			fmt.Fprintln(w)
+		}
+		for _, holder := range r.Holders {
+			fmt.Fprintf(w, "goroutine %v previously unlocked it from:\n", holder.Goroutine)
+			printFrames(w, holder.Stack)
+		}
+	case ForeignUnlock:
+		fmt.Fprintln(w, header, "Unlock from another goroutine:")
+		fmt.Fprintf(w, "goroutine %v unlock %s:\n", r.Lock.Goroutine, r.Lock.label())
+		printFrames(w, r.Lock.Stack)
+		for _, holder := range r.Holders {
+			fmt.Fprintf(w, "goroutine %v locked it from:\n", holder.Goroutine)
+			printFrames(w, holder.Stack)
+		}
+	case LockLevel:
+		fmt.Fprintln(w, header, "Lock level violation:")
+		fmt.Fprintf(w, "goroutine %v lock %s at level %d:\n", r.Lock.Goroutine, r.Lock.label(), r.Lock.Level)
+		printFrames(w, r.Lock.Stack)
+		for _, holder := range r.Holders {
+			fmt.Fprintf(w, "while holding lock %s at level %d taken at:\n", holder.label(), holder.Level)
+			printFrames(w, holder.Stack)
+		}
+	case NestedLocking:
+		fmt.Fprintln(w, header, "Nested locking of class", r.Lock.Class+":")
+		fmt.Fprintf(w, "goroutine %v lock %s:\n", r.Lock.Goroutine, r.Lock.label())
+		printFrames(w, r.Lock.Stack)
+		for _, holder := range r.Holders {
+			fmt.Fprintf(w, "while holding lock %s of the same class taken at:\n", holder.label())
+			printFrames(w, holder.Stack)
+		}
+	case WaitCycle:
+		fmt.Fprintln(w, header, "Goroutines waiting on each other:")
+		for _, edge := range r.Cycle {
+			if len(edge.Before.Stack) == 0 {
+				fmt.Fprintf(w, "goroutine %v is queued to lock %s ahead of the previous goroutine, at:\n",
+					edge.After.Goroutine, edge.After.label())
+				printFrames(w, edge.After.Stack)
+				continue
+			}
+			fmt.Fprintf(w, "goroutine %v holds lock %s, taken at:\n", edge.Before.Goroutine, edge.Before.label())
+			printFrames(w, edge.Before.Stack)
+			fmt.Fprintf(w, "and is waiting to lock %s at:\n", edge.After.label())
+			printFrames(w, edge.After.Stack)
+		}
+	default:
+		fmt.Fprintln(w, header, r.Kind)
+	}
+
+	if len(r.Others) > 0 {
+		fmt.Fprintln(w, "Other goroutines holding locks:")
+		for _, other := range r.Others {
+			fmt.Fprintf(w, "goroutine %v lock %s\n", other.Goroutine, other.label())
+			printFrames(w, other.Stack)
+		}
+		fmt.Fprintln(w)
+	}
+
+	if r.AllGoroutines != "" {
+		fmt.Fprintln(w, "All current goroutines:")
+		fmt.Fprint(w, r.AllGoroutines)
+	}
+	if r.Count > 1 {
+		fmt.Fprintf(w, "Occurred %d times since last reported.\n", r.Count)
+	}
+	fmt.Fprintln(w)
+}
+
+type jsonFrame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Offset   uint64 `json:"offset"`
+}
+
+type jsonLock struct {
+	Goroutine    int64       `json:"goroutine"`
+	Mutex        string      `json:"mutex,omitempty"`
+	Name         string      `json:"name,omitempty"`
+	Read         bool        `json:"read,omitempty"`
+	Level        int         `json:"level,omitempty"`
+	Class        string      `json:"class,omitempty"`
+	Count        int         `json:"count,omitempty"`
+	Stack        []jsonFrame `json:"stack"`
+	CurrentStack string      `json:"current_stack,omitempty"`
+}
+
+type jsonEdge struct {
+	Before jsonLock `json:"before"`
+	After  jsonLock `json:"after"`
+}
+
+type jsonReport struct {
+	Kind          string     `json:"kind"`
+	Severity      string     `json:"severity"`
+	Lock          jsonLock   `json:"lock"`
+	Timeout       string     `json:"timeout,omitempty"`
+	Holders       []jsonLock `json:"holders,omitempty"`
+	Cycle         []jsonEdge `json:"cycle,omitempty"`
+	Others        []jsonLock `json:"others,omitempty"`
+	AllGoroutines string     `json:"all_goroutines,omitempty"`
+	Count         int        `json:"count,omitempty"`
+}
+
+func toJSONFrames(frames []runtime.Frame) (jfs []jsonFrame) {
+	jfs = []jsonFrame{}
+	for _, frame := range frames {
+		jfs = append(jfs, jsonFrame{
+			Function: frame.Function,
+			File:     frame.File,
+			Line:     frame.Line,
+			Offset:   uint64(frame.PC - frame.Entry),
+		})
+	}
+	return
+}
+
+func toJSONLock(rl ReportLock) (jl jsonLock) {
+	jl.Goroutine = rl.Goroutine
+	if rl.Mutex != nil {
+		jl.Mutex = fmt.Sprintf("%p", rl.Mutex)
+	}
+	jl.Name = rl.Name
+	jl.Read = rl.Read
+	jl.Level = rl.Level
+	jl.Class = rl.Class
+	jl.Count = rl.Count
+	jl.Stack = toJSONFrames(rl.Stack)
+	jl.CurrentStack = rl.CurrentStack
+	return
+}
+
+func toJSONLocks(rls []ReportLock) (jls []jsonLock) {
+	for _, rl := range rls {
+		jls = append(jls, toJSONLock(rl))
+	}
+	return
+}
+
+// MarshalJSON implements json.Marshaler.
+// Mutexes are identified by their address and stack frames
+// by function, file, line and offset from the function entry.
+func (r *Report) MarshalJSON() ([]byte, error) {
+	jr := jsonReport{
+		Kind:          r.Kind.String(),
+		Severity:      r.Severity.String(),
+		Lock:          toJSONLock(r.Lock),
+		Holders:       toJSONLocks(r.Holders),
+		Others:        toJSONLocks(r.Others),
+		AllGoroutines: r.AllGoroutines,
+		Count:         r.Count,
+	}
+	if r.Timeout > 0 {
+		jr.Timeout = r.Timeout.String()
+	}
+	for _, edge := range r.Cycle {
+		jr.Cycle = append(jr.Cycle, jsonEdge{Before: toJSONLock(edge.Before), After: toJSONLock(edge.After)})
+	}
+	return json.Marshal(jr)
+}
+
+func (r *Report) write(w io.Writer, format Format) {
+	if format == FormatJSON {
+		if b, err := json.Marshal(r); err == nil {
+			_, _ = w.Write(append(b, '\n'))
+			return
+		}
+	}
+	_, _ = io.WriteString(w, r.String())
+}
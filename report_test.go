@@ -0,0 +1,320 @@
+package deadlock
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func captureReports() (*sync.Mutex, *[]*Report) {
+	var mu sync.Mutex
+	var reports []*Report
+	Opts.WriteLocked(func() {
+		Opts.OnPotentialDeadlock = nil
+		Opts.OnReport = func(r *Report) {
+			mu.Lock()
+			reports = append(reports, r)
+			mu.Unlock()
+		}
+	})
+	return &mu, &reports
+}
+
+func waitReports(t *testing.T, mu *sync.Mutex, reports *[]*Report, want int) []*Report {
+	t.Helper()
+	for waited := 0; waited < 1000; waited++ {
+		mu.Lock()
+		n := len(*reports)
+		mu.Unlock()
+		if n >= want {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(*reports) != want {
+		t.Fatal("expected", want, "reports, got", len(*reports))
+	}
+	return append([]*Report(nil), (*reports)...)
+}
+
+func hasFunction(r ReportLock, fn string) bool {
+	for _, frame := range r.Stack {
+		if strings.Contains(frame.Function, fn) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReport_RecursiveLocking(t *testing.T) {
+	defer restore()()
+	Opts.WriteLocked(func() { Opts.DeadlockTimeout = 0 })
+	mu, reports := captureReports()
+
+	var a DeadlockMutex
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Lock()
+		a.Lock()
+	}()
+	got := waitReports(t, mu, reports, 1)
+	unlock(&a)
+	<-done
+	unlock(&a)
+
+	r := got[0]
+	if r.Kind != RecursiveLocking {
+		t.Error(r.Kind)
+	}
+	if r.Lock.Mutex != &a || r.Lock.Goroutine == 0 {
+		t.Error(r.Lock)
+	}
+	if len(r.Holders) != 1 || r.Holders[0].Goroutine != r.Lock.Goroutine {
+		t.Error(r.Holders)
+	}
+	if !hasFunction(r.Lock, "TestReport_RecursiveLocking") {
+		t.Error(r.Lock.Stack)
+	}
+	if !strings.Contains(r.String(), "Recursive locking") {
+		t.Error(r.String())
+	}
+}
+
+func TestReport_InconsistentLocking(t *testing.T) {
+	defer restore()()
+	Opts.WriteLocked(func() { Opts.DeadlockTimeout = 0 })
+	mu, reports := captureReports()
+
+	var a, b DeadlockMutex
+	a.Lock()
+	b.Lock()
+	unlock(&b)
+	unlock(&a)
+	b.Lock()
+	a.Lock()
+	unlock(&a)
+	unlock(&b)
+
+	r := waitReports(t, mu, reports, 1)[0]
+	if r.Kind != InconsistentLocking {
+		t.Error(r.Kind)
+	}
+	if len(r.Cycle) != 2 {
+		t.Fatal(r.Cycle)
+	}
+	if r.Cycle[0].Before.Mutex != &a || r.Cycle[0].After.Mutex != &b {
+		t.Error(r.Cycle[0])
+	}
+	if r.Cycle[1].Before.Mutex != &b || r.Cycle[1].After.Mutex != &a {
+		t.Error(r.Cycle[1])
+	}
+}
+
+func TestReport_LockTimeout(t *testing.T) {
+	defer restore()()
+	Opts.WriteLocked(func() { Opts.DeadlockTimeout = time.Millisecond * 20 })
+	mu, reports := captureReports()
+
+	var a DeadlockMutex
+	a.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Lock()
+		unlock(&a)
+	}()
+	r := waitReports(t, mu, reports, 1)[0]
+	unlock(&a)
+	<-done
+
+	if r.Kind != LockTimeout || r.Timeout != time.Millisecond*20 {
+		t.Error(r.Kind, r.Timeout)
+	}
+	if len(r.Holders) != 1 || r.Holders[0].Goroutine == r.Lock.Goroutine {
+		t.Fatal(r.Holders)
+	}
+	if !hasFunction(r.Holders[0], "TestReport_LockTimeout") {
+		t.Error(r.Holders[0].Stack)
+	}
+	if !strings.Contains(r.Holders[0].CurrentStack, "waitReports") {
+		t.Error(r.Holders[0].CurrentStack)
+	}
+}
+
+func TestReportKind_String(t *testing.T) {
+	if s := InconsistentLocking.String(); s != "inconsistent locking" {
+		t.Error(s)
+	}
+	if s := ReportKind(0).String(); s != "ReportKind(0)" {
+		t.Error(s)
+	}
+}
+
+func TestReport_FormatJSON(t *testing.T) {
+	defer restore()()
+	var buf bytes.Buffer
+	Opts.WriteLocked(func() {
+		Opts.DeadlockTimeout = 0
+		Opts.LogBuf = &buf
+		Opts.ReportFormat = FormatJSON
+	})
+	mu, reports := captureReports()
+
+	var a, b DeadlockMutex
+	a.Lock()
+	b.Lock()
+	unlock(&b)
+	unlock(&a)
+	b.Lock()
+	a.Lock()
+	unlock(&a)
+	unlock(&b)
+	waitReports(t, mu, reports, 1)
+
+	var got struct {
+		Kind  string
+		Cycle []struct {
+			Before struct {
+				Goroutine int64
+				Mutex     string
+				Stack     []struct {
+					Function string
+					File     string
+					Line     int
+					Offset   uint64
+				}
+			}
+		}
+	}
+	if lines := bytes.Count(buf.Bytes(), []byte("\n")); lines != 1 {
+		t.Error("expected a single line, got", lines)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Kind != "inconsistent locking" || len(got.Cycle) != 2 {
+		t.Fatal(buf.String())
+	}
+	before := got.Cycle[0].Before
+	if before.Goroutine == 0 || !strings.HasPrefix(before.Mutex, "0x") || len(before.Stack) == 0 {
+		t.Fatal(buf.String())
+	}
+	if frame := before.Stack[len(before.Stack)-1]; !strings.Contains(frame.Function, "TestReport_FormatJSON") ||
+		!strings.HasSuffix(frame.File, "report_test.go") || frame.Line == 0 {
+		t.Error(frame)
+	}
+}
+
+func TestReport_MutexName(t *testing.T) {
+	defer restore()()
+	var buf bytes.Buffer
+	Opts.WriteLocked(func() {
+		Opts.DeadlockTimeout = 0
+		Opts.LogBuf = &buf
+	})
+	mu, reports := captureReports()
+
+	var a DeadlockRWMutex
+	a.SetName("config")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Lock()
+		a.Lock()
+	}()
+	r := waitReports(t, mu, reports, 1)[0]
+	unlock(&a)
+	<-done
+	unlock(&a)
+	if r.Lock.Name != "config" || r.Holders[0].Name != "config" {
+		t.Error(r.Lock.Name, r.Holders[0].Name)
+	}
+	if !strings.Contains(buf.String(), "lock config (0x") {
+		t.Error(buf.String())
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"name":"config"`) {
+		t.Error(string(b))
+	}
+}
+
+func TestReport_MultipleReaders(t *testing.T) {
+	defer restore()()
+	Opts.WriteLocked(func() { Opts.DeadlockTimeout = time.Millisecond * 20 })
+	mu, reports := captureReports()
+
+	var a DeadlockRWMutex
+	a.RLock()
+	readerLocked := make(chan struct{})
+	readerUnlock := make(chan struct{})
+	readerDone := make(chan struct{})
+	go func() {
+		defer close(readerDone)
+		a.RLock()
+		close(readerLocked)
+		<-readerUnlock
+		a.RUnlock()
+	}()
+	<-readerLocked
+
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		a.Lock()
+		unlock(&a)
+	}()
+	r := waitReports(t, mu, reports, 1)[0]
+	if len(r.Holders) != 2 || r.Holders[0].Goroutine == r.Holders[1].Goroutine {
+		t.Fatal(r.Holders)
+	}
+
+	close(readerUnlock)
+	<-readerDone
+	holders := defaultDetector.lo.holders(&a)
+	if len(holders) != 1 || holders[0].gid != getGoid() {
+		t.Error(holders)
+	}
+	a.RUnlock()
+	<-writerDone
+}
+
+func TestReport_HoldTimeout(t *testing.T) {
+	defer restore()()
+	Opts.WriteLocked(func() {
+		Opts.DeadlockTimeout = 0
+		Opts.MaxHoldTime = time.Millisecond * 20
+	})
+	mu, reports := captureReports()
+
+	var a, b DeadlockMutex
+	b.Lock()
+	unlock(&b)
+	a.Lock()
+	r := waitReports(t, mu, reports, 1)[0]
+	unlock(&a)
+
+	if r.Kind != HoldTimeout || r.Timeout != time.Millisecond*20 {
+		t.Error(r.Kind, r.Timeout)
+	}
+	if r.Lock.Mutex != &a || r.Lock.Goroutine != getGoid() {
+		t.Error(r.Lock)
+	}
+	if !hasFunction(r.Lock, "TestReport_HoldTimeout") {
+		t.Error(r.Lock.Stack)
+	}
+	if !strings.Contains(r.Lock.CurrentStack, "waitReports") {
+		t.Error(r.Lock.CurrentStack)
+	}
+	if !strings.Contains(r.String(), "have been holding lock") {
+		t.Error(r.String())
+	}
+}
@@ -17,16 +17,27 @@ func callers(skip int) (retv []uintptr) {
 	return
 }
 
-func printStack(w io.Writer, stack []uintptr) {
-	frames := runtime.CallersFrames(stack)
-	var frame runtime.Frame
-	more := true
-	for more {
-		frame, more = frames.Next()
-		if strings.HasPrefix(frame.Function, "runtime.goexit") ||
-			strings.HasPrefix(frame.Function, "testing.tRunner") {
-			break
+// stackFrames resolves a stack from callers(), stopping at the
+// goroutine entry or the testing framework.
+func stackFrames(stack []uintptr) (retv []runtime.Frame) {
+	if len(stack) > 0 {
+		frames := runtime.CallersFrames(stack)
+		more := true
+		for more {
+			var frame runtime.Frame
+			frame, more = frames.Next()
+			if strings.HasPrefix(frame.Function, "runtime.goexit") ||
+				strings.HasPrefix(frame.Function, "testing.tRunner") {
+				break
+			}
+			retv = append(retv, frame)
 		}
+	}
+	return
+}
+
+func printFrames(w io.Writer, frames []runtime.Frame) {
+	for _, frame := range frames {
 		fmt.Fprintf(w, "  %s()\n", frame.Function)
 		fmt.Fprintf(w, "      %s:%d +0x%x\n", frame.File, frame.Line, frame.PC-frame.Entry)
 	}
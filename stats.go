@@ -0,0 +1,97 @@
+package deadlock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// LockStats holds the statistics collected for a mutex while Options.CollectStats is set.
+type LockStats struct {
+	Mutex        interface{}   // the mutex
+	Name         string        // name of the mutex, if set with SetName
+	Acquisitions uint64        // number of times the mutex was locked
+	Contended    uint64        // number of times the mutex was not immediately available, requires go 1.18
+	WaitTotal    time.Duration // total time spent waiting to lock the mutex
+	WaitMax      time.Duration // longest time spent waiting to lock the mutex
+	HoldTotal    time.Duration // total time the mutex was held
+	HoldMax      time.Duration // longest time the mutex was held
+}
+
+type lockStats struct {
+	mu sync.Mutex
+	m  map[interface{}]*LockStats
+}
+
+func (s *lockStats) get(mtx interface{}) *LockStats {
+	ls := s.m[mtx]
+	if ls == nil {
+		ls = &LockStats{Mutex: mtx}
+		s.m[mtx] = ls
+	}
+	return ls
+}
+
+func (s *lockStats) acquired(mtx interface{}, contended bool, wait time.Duration) {
+	s.mu.Lock()
+	ls := s.get(mtx)
+	ls.Acquisitions++
+	if contended {
+		ls.Contended++
+	}
+	ls.WaitTotal += wait
+	if wait > ls.WaitMax {
+		ls.WaitMax = wait
+	}
+	s.mu.Unlock()
+}
+
+func (s *lockStats) released(mtx interface{}, hold time.Duration) {
+	s.mu.Lock()
+	ls := s.get(mtx)
+	ls.HoldTotal += hold
+	if hold > ls.HoldMax {
+		ls.HoldMax = hold
+	}
+	s.mu.Unlock()
+}
+
+// Stats returns the statistics collected so far by the default Detector.
+// See Detector.Stats.
+func Stats() []LockStats {
+	return defaultDetector.Stats()
+}
+
+// Stats returns the statistics collected so far while Options.CollectStats was set,
+// ordered by decreasing total wait time.
+//
+// Note that mutexes that have statistics are not garbage collected until ResetStats is called.
+func (d *Detector) Stats() (retv []LockStats) {
+	d.stats.mu.Lock()
+	for _, ls := range d.stats.m {
+		retv = append(retv, *ls)
+	}
+	d.stats.mu.Unlock()
+	for i := range retv {
+		retv[i].Name = mutexName(retv[i].Mutex)
+	}
+	sort.Slice(retv, func(i, j int) bool {
+		if retv[i].WaitTotal != retv[j].WaitTotal {
+			return retv[i].WaitTotal > retv[j].WaitTotal
+		}
+		return retv[i].HoldTotal > retv[j].HoldTotal
+	})
+	return
+}
+
+// ResetStats discards all statistics collected so far by the default Detector.
+func ResetStats() {
+	defaultDetector.ResetStats()
+}
+
+// ResetStats discards all statistics collected so far.
+func (d *Detector) ResetStats() {
+	d.stats.mu.Lock()
+	d.stats.m = map[interface{}]*LockStats{}
+	d.stats.mu.Unlock()
+}
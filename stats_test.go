@@ -0,0 +1,66 @@
+package deadlock
+
+import (
+	"testing"
+	"time"
+)
+
+func lockStatsFor(mtx interface{}) (ls LockStats) {
+	for _, ls = range Stats() {
+		if ls.Mutex == mtx {
+			return
+		}
+	}
+	return LockStats{}
+}
+
+func TestStats(t *testing.T) {
+	defer restore()()
+	defer ResetStats()
+	Opts.WriteLocked(func() {
+		Opts.CollectStats = true
+	})
+
+	var a, b DeadlockRWMutex
+	a.SetName("a")
+	a.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.RLock()
+		a.RUnlock()
+	}()
+	time.Sleep(time.Millisecond * 20)
+	unlock(&a)
+	<-done
+	b.RLock()
+	b.RUnlock()
+
+	ls := lockStatsFor(&a)
+	if ls.Name != "a" || ls.Acquisitions != 2 {
+		t.Error(ls)
+	}
+	if ls.WaitMax < time.Millisecond*10 || ls.WaitTotal < ls.WaitMax {
+		t.Error(ls)
+	}
+	if ls.HoldMax < time.Millisecond*10 || ls.HoldTotal < ls.HoldMax {
+		t.Error(ls)
+	}
+	if s := Stats(); len(s) != 2 || s[0].Mutex != &a || s[1].Mutex != &b {
+		t.Error(s)
+	}
+
+	ResetStats()
+	if s := Stats(); len(s) != 0 {
+		t.Error(s)
+	}
+
+	Opts.WriteLocked(func() {
+		Opts.CollectStats = false
+	})
+	a.Lock()
+	unlock(&a)
+	if s := Stats(); len(s) != 0 {
+		t.Error(s)
+	}
+}
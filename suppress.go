@@ -0,0 +1,111 @@
+package deadlock
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// A Suppression silences InconsistentLocking reports for lock order cycles
+// known to be safe, such as inversions that only happen during single-threaded
+// initialization. A cycle is not reported if any of its edges was taken with
+// a frame matching Before in the stack of the lock taken first, and a frame
+// matching After in the stack of the lock taken second.
+//
+// Patterns match the end of either the function name or the "file:line" of
+// a frame, and may contain '*' to match any sequence of characters.
+// An empty pattern matches any stack.
+type Suppression struct {
+	Before string
+	After  string
+}
+
+// ParseSuppressions reads suppressions from r, one per line in the form
+//
+//	order:BEFORE [AFTER]
+//
+// where BEFORE and AFTER are patterns as described for Suppression.
+// Empty lines and lines starting with '#' are ignored.
+func ParseSuppressions(r io.Reader) (sups []Suppression, err error) {
+	sc := bufio.NewScanner(r)
+	for lineno := 1; sc.Scan(); lineno++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if !strings.HasPrefix(fields[0], "order:") || len(fields) > 2 {
+			return nil, fmt.Errorf("deadlock: suppressions line %d: expected \"order:BEFORE [AFTER]\", got %q", lineno, line)
+		}
+		sup := Suppression{Before: strings.TrimPrefix(fields[0], "order:")}
+		if len(fields) > 1 {
+			sup.After = fields[1]
+		}
+		sups = append(sups, sup)
+	}
+	return sups, sc.Err()
+}
+
+// LoadSuppressions reads suppressions from the named file. See ParseSuppressions.
+func LoadSuppressions(name string) ([]Suppression, error) {
+	f, err := os.Open(name) //#nosec G304
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseSuppressions(f)
+}
+
+func (sup Suppression) matches(edge ReportEdge) bool {
+	return stackMatches(sup.Before, edge.Before.Stack) && stackMatches(sup.After, edge.After.Stack)
+}
+
+func stackMatches(pattern string, frames []runtime.Frame) bool {
+	if pattern == "" {
+		return true
+	}
+	for _, frame := range frames {
+		if globSuffix(pattern, frame.Function) || globSuffix(pattern, frame.File+":"+strconv.Itoa(frame.Line)) {
+			return true
+		}
+	}
+	return false
+}
+
+// globSuffix returns true if pattern matches the end of s,
+// with '*' in pattern matching any sequence of characters.
+func globSuffix(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	last := parts[len(parts)-1]
+	if !strings.HasSuffix(s, last) {
+		return false
+	}
+	s = s[:len(s)-len(last)]
+	// match the remaining parts from the end, as late as possible
+	for i := len(parts) - 2; i >= 0; i-- {
+		j := strings.LastIndex(s, parts[i])
+		if j < 0 {
+			return false
+		}
+		s = s[:j]
+	}
+	return true
+}
+
+// suppressed returns true if r is an InconsistentLocking report matching one of sups.
+func (r *Report) suppressed(sups []Suppression) bool {
+	if r.Kind == InconsistentLocking {
+		for _, sup := range sups {
+			for _, edge := range r.Cycle {
+				if sup.matches(edge) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package deadlock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSuppressions(t *testing.T) {
+	sups, err := ParseSuppressions(strings.NewReader(`
+# accepted inversions
+order:pkg.initTables
+order:foo.go:12  *.(*Cache).Get
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sups) != 2 || sups[0] != (Suppression{Before: "pkg.initTables"}) ||
+		sups[1] != (Suppression{Before: "foo.go:12", After: "*.(*Cache).Get"}) {
+		t.Error(sups)
+	}
+	if _, err := ParseSuppressions(strings.NewReader("race:foo\n")); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Error(err)
+	}
+}
+
+func TestLoadSuppressions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadlock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "suppressions.txt")
+	if err := ioutil.WriteFile(name, []byte("order:a b\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sups, err := LoadSuppressions(name)
+	if err != nil || len(sups) != 1 || sups[0] != (Suppression{Before: "a", After: "b"}) {
+		t.Error(sups, err)
+	}
+	if _, err := LoadSuppressions(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestGlobSuffix(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"pkg.Func", "github.com/a/pkg.Func", true},
+		{"pkg.Func", "github.com/a/pkg.Func2", false},
+		{"foo.go:12", "/src/foo.go:12", true},
+		{"foo.go:12", "/src/foo.go:123", false},
+		{"pkg.*.Get", "github.com/a/pkg.(*Cache).Get", true},
+		{"pkg.init*", "github.com/a/pkg.init.0", true},
+		{"a*b*c", "xaybzc", true},
+		{"a*b*c", "xbyazc", false},
+	} {
+		if got := globSuffix(tc.pattern, tc.s); got != tc.want {
+			t.Error(tc.pattern, tc.s, got)
+		}
+	}
+}
+
+func lockInOrder(first, second *DeadlockMutex) {
+	first.Lock()
+	second.Lock()
+	unlock(second)
+	unlock(first)
+}
+
+func TestSuppressions(t *testing.T) {
+	defer restore()()
+	Opts.WriteLocked(func() {
+		Opts.DeadlockTimeout = 0
+		Opts.Suppressions = []Suppression{{Before: "deadlock.lockInOrder", After: "suppress_test.go:*"}}
+	})
+	mu, reports := captureReports()
+
+	// suppressed, since the a→b edge was taken in lockInOrder
+	var a, b DeadlockMutex
+	lockInOrder(&a, &b)
+	b.Lock()
+	a.Lock()
+	unlock(&a)
+	unlock(&b)
+
+	// not suppressed
+	var c, d DeadlockMutex
+	c.Lock()
+	d.Lock()
+	unlock(&d)
+	unlock(&c)
+	d.Lock()
+	c.Lock()
+	unlock(&c)
+	unlock(&d)
+
+	r := waitReports(t, mu, reports, 1)[0]
+	if r.Kind != InconsistentLocking || r.Cycle[0].Before.Mutex != &c {
+		t.Error(r.Kind, r.Cycle)
+	}
+}
@@ -0,0 +1,97 @@
+package deadlock
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// maxLastUnlocks bounds the number of mutexes whose last unlock is remembered for CheckUnlock.
+const maxLastUnlocks = 4096
+
+// unlockedStack is where a lock was last unlocked.
+type unlockedStack struct {
+	stackGID
+	seen uint64 // value of lockOrder.unlockCount when last unlocked
+}
+
+// preUnlock checks an unlock of curMtx before it is delegated,
+// if Opts.CheckUnlock or Opts.WarnForeignUnlock is set.
+func (d *Detector) preUnlock(curMtx interface{}, read bool) {
+	checkLocked := atomic.LoadInt32(&d.checkUnlock) != 0
+	checkOwner := atomic.LoadInt32(&d.warnForeignUnlock) != 0
+	if checkLocked || checkOwner {
+		d.lo.preUnlock(getGoid(), callers(2), curMtx, read, checkLocked, checkOwner)
+	}
+}
+
+func (l *lockOrder) preUnlock(gid int64, curStack []uintptr, curMtx interface{}, read, checkLocked, checkOwner bool) {
+	holders := l.holders(curMtx)
+	var locked, owned bool
+	for _, holder := range holders {
+		if holder.read == read {
+			locked = true
+			owned = owned || holder.gid == gid
+		}
+	}
+
+	if checkLocked {
+		if !locked {
+			r := &Report{
+				Kind: UnlockOfUnlocked,
+				Lock: reportLock(gid, curMtx, curStack, read),
+			}
+			l.unlockMu.Lock()
+			prev, ok := l.lastUnlock[curMtx]
+			l.unlockMu.Unlock()
+			if ok {
+				r.Holders = []ReportLock{reportLock(prev.gid, curMtx, prev.stack, prev.read)}
+			}
+			l.d.Opts.report(r)
+		}
+		l.unlockMu.Lock()
+		// Evict the least recently unlocked to keep memory footprint bounded,
+		// in batches so that the cost of finding them is spread over many unlocks.
+		if _, ok := l.lastUnlock[curMtx]; !ok && len(l.lastUnlock) >= maxLastUnlocks {
+			l.evictUnlocks(len(l.lastUnlock) - maxLastUnlocks + 1 + maxLastUnlocks/16)
+		}
+		l.unlockCount++
+		l.lastUnlock[curMtx] = unlockedStack{stackGID: stackGID{stack: curStack, gid: gid, read: read}, seen: l.unlockCount}
+		l.unlockMu.Unlock()
+	}
+
+	if checkOwner && locked && !owned {
+		r := &Report{
+			Kind:     ForeignUnlock,
+			Severity: SeverityWarning,
+			Lock:     reportLock(gid, curMtx, curStack, read),
+		}
+		for _, holder := range holders {
+			if holder.read == read {
+				r.Holders = append(r.Holders, reportLock(holder.gid, curMtx, holder.stack, holder.read))
+			}
+		}
+		l.d.Opts.report(r)
+	}
+}
+
+// evictUnlocks forgets the n least recently unlocked mutexes in lastUnlock.
+// Must be called with l.unlockMu held.
+func (l *lockOrder) evictUnlocks(n int) {
+	seen := make([]uint64, 0, len(l.lastUnlock))
+	for _, prev := range l.lastUnlock {
+		seen = append(seen, prev.seen)
+	}
+	sort.Slice(seen, func(i, j int) bool { return seen[i] < seen[j] })
+	if n > len(seen) {
+		n = len(seen)
+	}
+	if n < 1 {
+		return
+	}
+	cutoff := seen[n-1]
+	for mtx, prev := range l.lastUnlock {
+		if prev.seen <= cutoff {
+			delete(l.lastUnlock, mtx)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package deadlock
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type unlockPanic struct{}
+
+func TestCheckUnlock(t *testing.T) {
+	defer restore()()
+	var reports []*Report
+	Opts.WriteLocked(func() {
+		Opts.CheckUnlock = true
+		Opts.OnPotentialDeadlock = nil
+		Opts.OnReport = func(r *Report) {
+			reports = append(reports, r)
+			// Stop the unlock from reaching sync, which would be fatal.
+			panic(unlockPanic{})
+		}
+	})
+
+	var a DeadlockRWMutex
+	a.Lock()
+	a.Unlock()
+	a.RLock()
+	func() {
+		defer func() {
+			if _, ok := recover().(unlockPanic); !ok {
+				t.Error("expected unlock to be reported")
+			}
+		}()
+		a.Unlock()
+	}()
+	a.RUnlock()
+
+	if len(reports) != 1 {
+		t.Fatal(reports)
+	}
+	r := reports[0]
+	if r.Kind != UnlockOfUnlocked || r.Lock.Mutex != &a || r.Lock.Read {
+		t.Error(r.Kind, r.Lock)
+	}
+	if !hasFunction(r.Lock, "TestCheckUnlock.func") {
+		t.Error(r.Lock.Stack)
+	}
+	if len(r.Holders) != 1 || !hasFunction(r.Holders[0], "TestCheckUnlock") {
+		t.Fatal(r.Holders)
+	}
+	if s := r.String(); !strings.Contains(s, "previously unlocked it from") {
+		t.Error(s)
+	}
+}
+
+func TestWarnForeignUnlock(t *testing.T) {
+	defer restore()()
+	Opts.WriteLocked(func() { Opts.WarnForeignUnlock = true })
+	mu, reports := captureReports()
+
+	var a DeadlockMutex
+	a.Lock()
+	a.Unlock()
+	a.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Unlock()
+	}()
+	<-done
+
+	r := waitReports(t, mu, reports, 1)[0]
+	if r.Kind != ForeignUnlock || r.Severity != SeverityWarning || r.Lock.Mutex != &a {
+		t.Error(r.Kind, r.Severity, r.Lock)
+	}
+	if len(r.Holders) != 1 || r.Holders[0].Goroutine != getGoid() || r.Lock.Goroutine == getGoid() {
+		t.Error(r.Lock.Goroutine, r.Holders)
+	}
+}
+
+func TestForeignUnlock_RelockedBefore(t *testing.T) {
+	d, _, _ := newTestDetector()
+	l := d.lo
+	var a DeadlockMutex
+	stack := callers(0)
+
+	// goroutine 1 locks a, goroutine 2 unlocks it, and goroutine 3 locks it
+	// again before the unlock is recorded
+	l.postLock(1, stack, &a, false, time.Time{})
+	l.postLock(3, stack, &a, false, time.Time{})
+	l.postUnlock(2, &a)
+
+	holders := l.holders(&a)
+	if len(holders) != 1 || holders[0].gid != 3 {
+		t.Error(holders)
+	}
+}
+
+func TestCheckUnlock_NoLockOrder(t *testing.T) {
+	d, mu, reports := newTestDetector()
+	d.Opts.WriteLocked(func() { d.Opts.MaxMapSize = 0 })
+	l := d.lo
+	var a DeadlockMutex
+	stack := callers(0)
+
+	// the last unlock is remembered without lock order tracking
+	l.postLock(1, stack, &a, false, time.Time{})
+	l.preUnlock(1, stack, &a, false, true, false)
+	l.postUnlock(1, &a)
+	l.preUnlock(2, stack, &a, false, true, false)
+
+	r := waitReports(t, mu, reports, 1)[0]
+	if r.Kind != UnlockOfUnlocked || len(r.Holders) != 1 || r.Holders[0].Goroutine != 1 {
+		t.Error(r.Kind, r.Holders)
+	}
+}
+
+func TestCheckUnlock_Evict(t *testing.T) {
+	d, _, _ := newTestDetector()
+	l := d.lo
+	mtxs := make([]DeadlockMutex, maxLastUnlocks+1)
+	stack := callers(0)
+	unlockAll := func(mtxs []DeadlockMutex) {
+		for i := range mtxs {
+			l.postLock(1, stack, &mtxs[i], false, time.Time{})
+			l.preUnlock(1, stack, &mtxs[i], false, true, false)
+			l.postUnlock(1, &mtxs[i])
+		}
+	}
+	unlockAll(mtxs[:maxLastUnlocks])
+	// recently unlocked again, so kept
+	unlockAll(mtxs[:1])
+	unlockAll(mtxs[maxLastUnlocks:])
+
+	l.unlockMu.Lock()
+	defer l.unlockMu.Unlock()
+	if n := len(l.lastUnlock); n >= maxLastUnlocks || n < maxLastUnlocks*7/8 {
+		t.Error(n)
+	}
+	for _, i := range []int{0, maxLastUnlocks - 1, maxLastUnlocks} {
+		if _, ok := l.lastUnlock[&mtxs[i]]; !ok {
+			t.Error("expected", i, "to be kept")
+		}
+	}
+	if _, ok := l.lastUnlock[&mtxs[1]]; ok {
+		t.Error("expected the least recently unlocked to be evicted")
+	}
+}
@@ -0,0 +1,146 @@
+package deadlock
+
+import "sort"
+
+// A waitEdge is an edge of the wait-for graph; a goroutine waiting to lock
+// a mutex waits for goroutine to.
+type waitEdge struct {
+	to   *waiter   // the wait of the goroutine waited for
+	held *heldLock // the lock it holds on the mutex, nil if it is a writer queued before a reader
+}
+
+// waitCycles returns the cycles of goroutines in waiting that each wait to
+// lock a mutex held by the next, or for a writer queued to lock it.
+// Each cycle lists the edges leading to each of its goroutines.
+func (l *lockOrder) waitCycles(waiting []*waiter) (cycles [][]waitEdge) {
+	byGID := map[int64]*waiter{}
+	byMtx := map[interface{}][]*waiter{}
+	var nodes []*waiter
+	for _, w := range waiting {
+		if w.kind == LockTimeout {
+			byGID[w.gid] = w
+			byMtx[w.mtx] = append(byMtx[w.mtx], w)
+			nodes = append(nodes, w)
+		}
+	}
+	if len(nodes) < 2 {
+		return nil
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].since.Before(nodes[j].since) })
+
+	// only goroutines that are waiting themselves can be part of a cycle
+	holders := map[interface{}][]heldLock{}
+	for _, h := range l.collect(func(h *heldLock) bool { return byMtx[h.mtx] != nil && byGID[h.gid] != nil }) {
+		holders[h.mtx] = append(holders[h.mtx], h)
+	}
+
+	successors := func(w *waiter) (edges []waitEdge) {
+		hs := holders[w.mtx]
+		for i := range hs {
+			if to := byGID[hs[i].gid]; to != w && !(w.read && hs[i].read) {
+				edges = append(edges, waitEdge{to: to, held: &hs[i]})
+			}
+		}
+		if w.read {
+			// a pending writer blocks readers that come after it
+			for _, to := range byMtx[w.mtx] {
+				if to != w && !to.read {
+					edges = append(edges, waitEdge{to: to})
+				}
+			}
+		}
+		return
+	}
+
+	const (
+		unvisited = iota
+		onPath
+		done
+	)
+	state := map[*waiter]int{}
+	var path []waitEdge
+	var visit func(w *waiter) []waitEdge
+	visit = func(w *waiter) []waitEdge {
+		state[w] = onPath
+		for _, e := range successors(w) {
+			switch state[e.to] {
+			case onPath:
+				i := len(path) - 1
+				for i >= 0 && path[i].to != e.to {
+					i--
+				}
+				return append(append([]waitEdge(nil), path[i+1:]...), e)
+			case unvisited:
+				path = append(path, e)
+				if cycle := visit(e.to); cycle != nil {
+					return cycle
+				}
+				path = path[:len(path)-1]
+			}
+		}
+		state[w] = done
+		return nil
+	}
+	for _, w := range nodes {
+		if state[w] == unvisited {
+			path = path[:0]
+			if cycle := visit(w); cycle != nil {
+				cycles = append(cycles, cycle)
+				for k, s := range state {
+					if s == onPath {
+						state[k] = done
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+// checkWaitCycles reports the cycles among waiting found in two consecutive
+// checks, since the waiters in them then cannot have made progress.
+func (d *Detector) checkWaitCycles(waiting []*waiter) {
+	cycles := d.lo.waitCycles(waiting)
+	var confirmed [][]waitEdge
+	inCycle := map[*waiter]bool{}
+	d.waiters.mu.Lock()
+	for _, cycle := range cycles {
+		seen := true
+		for _, e := range cycle {
+			inCycle[e.to] = true
+			seen = seen && e.to.inCycle && e.to.index >= 0
+		}
+		if seen {
+			for _, e := range cycle {
+				e.to.cycleReported = true
+				e.to.reported = true
+			}
+			confirmed = append(confirmed, cycle)
+		}
+	}
+	for _, w := range waiting {
+		w.inCycle = inCycle[w]
+	}
+	d.waiters.mu.Unlock()
+	for _, cycle := range confirmed {
+		d.reportWaitCycle(cycle)
+	}
+}
+
+func (d *Detector) reportWaitCycle(cycle []waitEdge) {
+	r := &Report{Kind: WaitCycle}
+	for _, e := range cycle {
+		w := e.to
+		after := reportLock(w.gid, w.mtx, w.stack, w.read)
+		before := ReportLock{Goroutine: w.gid, Mutex: w.mtx, Name: after.Name, Level: after.Level, Class: after.Class}
+		if e.held != nil {
+			before = reportLock(e.held.gid, e.held.mtx, e.held.stack, e.held.read)
+		}
+		r.Cycle = append(r.Cycle, ReportEdge{Before: before, After: after})
+	}
+	r.Lock = r.Cycle[0].After
+	if d.Opts.PrintAllCurrentGoroutinesEnabled() {
+		r.AllGoroutines = string(stacks())
+	}
+	d.Opts.report(r)
+}
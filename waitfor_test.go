@@ -0,0 +1,176 @@
+package deadlock
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newWaitCycleDetector() (*Detector, func(t *testing.T, want int) []*Report) {
+	d, mu, reports := newTestDetector()
+	d.Opts.WriteLocked(func() {
+		d.Opts.MaxMapSize = 0
+		d.Opts.DetectWaitCycles = true
+		d.Opts.WatchdogInterval = time.Millisecond * 5
+	})
+	return d, func(t *testing.T, want int) []*Report {
+		t.Helper()
+		return waitReports(t, mu, reports, want)
+	}
+}
+
+// waitForWaiters waits until n goroutines are waiting in d.
+func waitForWaiters(t *testing.T, d *Detector, n int) {
+	t.Helper()
+	for waited := 0; waited < 1000; waited++ {
+		if got, _ := numWaiters(d); got >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("expected", n, "waiters")
+}
+
+func TestWaitCycle(t *testing.T) {
+	d, waitReports := newWaitCycleDetector()
+	var a, b DeadlockMutex
+	a.SetDetector(d)
+	b.SetDetector(d)
+	a.SetName("a")
+	b.SetName("b")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	locked := make(chan struct{})
+	start := make(chan struct{})
+	done := make(chan error)
+	lockPair := func(first, second *DeadlockMutex) {
+		first.Lock()
+		locked <- struct{}{}
+		<-start
+		err := second.LockContext(ctx)
+		if err == nil {
+			unlock(second)
+		}
+		unlock(first)
+		done <- err
+	}
+	go lockPair(&a, &b)
+	go lockPair(&b, &a)
+	<-locked
+	<-locked
+	close(start)
+
+	r := waitReports(t, 1)[0]
+	cancel()
+	for i := 0; i < 2; i++ {
+		<-done
+	}
+
+	if r.Kind != WaitCycle || r.Severity != SeverityError || len(r.Cycle) != 2 {
+		t.Fatal(r.Kind, r.Cycle)
+	}
+	for i, edge := range r.Cycle {
+		next := r.Cycle[(i+1)%len(r.Cycle)]
+		if edge.Before.Goroutine != edge.After.Goroutine || edge.Before.Mutex == edge.After.Mutex {
+			t.Error(edge)
+		}
+		if edge.After.Mutex != next.Before.Mutex || edge.After.Goroutine == next.Before.Goroutine {
+			t.Error(edge, next)
+		}
+		if !hasFunction(edge.Before, "TestWaitCycle") || !hasFunction(edge.After, "TestWaitCycle") {
+			t.Error(edge)
+		}
+	}
+	if r.Lock.Mutex != r.Cycle[0].After.Mutex {
+		t.Error(r.Lock)
+	}
+	if s := r.String(); !strings.Contains(s, "Goroutines waiting on each other") || !strings.Contains(s, "holds lock a (") {
+		t.Error(s)
+	}
+}
+
+func TestWaitCycle_PendingWriter(t *testing.T) {
+	d, waitReports := newWaitCycleDetector()
+	var rw DeadlockRWMutex
+	var x DeadlockMutex
+	rw.SetDetector(d)
+	x.SetDetector(d)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 3)
+
+	// reader read locks rw, then waits for x
+	rLocked := make(chan struct{})
+	xLock := make(chan struct{})
+	go func() {
+		rw.RLock()
+		close(rLocked)
+		<-xLock
+		err := x.LockContext(ctx)
+		if err == nil {
+			unlock(&x)
+		}
+		rw.RUnlock()
+		done <- err
+	}()
+	<-rLocked
+
+	// locker locks x, then waits to read lock rw behind the writer
+	xLocked := make(chan struct{})
+	rLock := make(chan struct{})
+	go func() {
+		x.Lock()
+		close(xLocked)
+		<-rLock
+		err := rw.RLockContext(ctx)
+		if err == nil {
+			rw.RUnlock()
+		}
+		unlock(&x)
+		done <- err
+	}()
+	<-xLocked
+
+	// writer waits for reader
+	go func() {
+		err := rw.LockContext(ctx)
+		if err == nil {
+			unlock(&rw)
+		}
+		done <- err
+	}()
+	waitForWaiters(t, d, 1)
+	close(rLock)
+	waitForWaiters(t, d, 2)
+
+	// no cycle until reader waits for x
+	time.Sleep(time.Millisecond * 20)
+	close(xLock)
+	r := waitReports(t, 1)[0]
+	cancel()
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+
+	if r.Kind != WaitCycle || len(r.Cycle) != 3 {
+		t.Fatal(r.Kind, r.Cycle)
+	}
+	queued := 0
+	for _, edge := range r.Cycle {
+		if len(edge.Before.Stack) == 0 {
+			queued++
+			if edge.After.Mutex != &rw || edge.After.Read {
+				t.Error(edge.After)
+			}
+		}
+	}
+	if queued != 1 {
+		t.Error("expected one queued writer, got", queued)
+	}
+	if s := r.String(); !strings.Contains(s, "is queued to lock") {
+		t.Error(s)
+	}
+}
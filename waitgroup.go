@@ -0,0 +1,106 @@
+package deadlock
+
+import (
+	"sync"
+)
+
+// A DeadlockWaitGroup is a drop-in replacement for sync.WaitGroup.
+//
+// Waiting for longer than Opts.DeadlockTimeout is reported together
+// with the Add calls that have not yet been matched by a Done.
+type DeadlockWaitGroup struct {
+	wg   sync.WaitGroup
+	meta lockMeta
+	mu   sync.Mutex     // protects following
+	adds []waitGroupAdd // call sites of Add with an outstanding count, oldest first
+}
+
+type waitGroupAdd struct {
+	stackGID
+	count int
+}
+
+// Add adds delta, which may be negative, to the WaitGroup counter.
+// See sync.WaitGroup.Add.
+//
+// Since Done can't tell which Add it matches, the outstanding counts
+// are decremented from the oldest Add call site first.
+func (wg *DeadlockWaitGroup) Add(delta int) {
+	wg.add(delta, callers(1))
+}
+
+// Done decrements the WaitGroup counter by one.
+func (wg *DeadlockWaitGroup) Done() {
+	wg.add(-1, nil)
+}
+
+// Go calls f in a new goroutine and adds that task to the WaitGroup.
+// When f returns, the task is removed from the WaitGroup.
+func (wg *DeadlockWaitGroup) Go(f func()) {
+	wg.add(1, callers(1))
+	go func() {
+		defer wg.Done()
+		f()
+	}()
+}
+
+func (wg *DeadlockWaitGroup) add(delta int, stack []uintptr) {
+	wg.mu.Lock()
+	if delta > 0 {
+		i := 0
+		for i < len(wg.adds) && wg.adds[i].stack[0] != stack[0] {
+			i++
+		}
+		if i == len(wg.adds) {
+			wg.adds = append(wg.adds, waitGroupAdd{stackGID: stackGID{stack: stack, gid: getGoid()}})
+		}
+		wg.adds[i].count += delta
+	}
+	for done := -delta; done > 0 && len(wg.adds) > 0; {
+		if wg.adds[0].count > done {
+			wg.adds[0].count -= done
+			break
+		}
+		done -= wg.adds[0].count
+		wg.adds = wg.adds[1:]
+	}
+	wg.mu.Unlock()
+	wg.wg.Add(delta)
+}
+
+// Wait blocks until the WaitGroup counter is zero.
+//
+// Logs potential deadlocks to Opts.LogBuf,
+// calling Opts.OnPotentialDeadlock on each occasion.
+func (wg *DeadlockWaitGroup) Wait() {
+	gid := getGoid()
+	curStack := callers(1)
+	d := wg.meta.detector()
+	d.checkBlocking(gid, curStack, wg, nil)
+	if w := d.startWait(WaitGroupTimeout, gid, curStack, wg, false); w != nil {
+		defer d.endWait(w)
+	}
+	wg.wg.Wait()
+}
+
+// reportTimeout reports w as having waited on wg for longer than w.timeout.
+func (wg *DeadlockWaitGroup) reportTimeout(d *Detector, w *waiter) {
+	r := &Report{
+		Kind:    WaitGroupTimeout,
+		Lock:    reportLock(w.gid, wg, w.stack, false),
+		Timeout: w.timeout,
+	}
+	wg.mu.Lock()
+	adds := append([]waitGroupAdd(nil), wg.adds...)
+	wg.mu.Unlock()
+	for _, add := range adds {
+		holder := reportLock(add.gid, wg, add.stack, false)
+		holder.Count = add.count
+		r.Holders = append(r.Holders, holder)
+	}
+	r.Others = d.lo.otherLocked(nil)
+	if d.Opts.PrintAllCurrentGoroutinesEnabled() {
+		r.AllGoroutines = string(stacks())
+	}
+	d.Opts.report(r)
+}
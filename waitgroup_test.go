@@ -0,0 +1,88 @@
+package deadlock
+
+import (
+	"testing"
+	"time"
+)
+
+//go:noinline
+func addTwo(wg *DeadlockWaitGroup) {
+	wg.Add(2)
+}
+
+func TestDeadlockWaitGroup(t *testing.T) {
+	var wg DeadlockWaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Go(func() {
+			time.Sleep(time.Millisecond)
+		})
+		addTwo(&wg)
+		wg.Done()
+		wg.Add(-1)
+	}
+	wg.Wait()
+	wg.mu.Lock()
+	if len(wg.adds) != 0 {
+		t.Error(wg.adds)
+	}
+	wg.mu.Unlock()
+}
+
+func TestWaitGroup_Go(t *testing.T) {
+	var wg WaitGroup
+	var mu Mutex
+	n := 0
+	for i := 0; i < 10; i++ {
+		wg.Go(func() {
+			mu.Lock()
+			n++
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	if n != 10 {
+		t.Error(n)
+	}
+}
+
+func TestDeadlockWaitGroup_Timeout(t *testing.T) {
+	defer restore()()
+	Opts.WriteLocked(func() { Opts.DeadlockTimeout = time.Millisecond * 20 })
+	mu, reports := captureReports()
+
+	var wg DeadlockWaitGroup
+	addTwo(&wg)
+	addTwo(&wg)
+	wg.Add(1)
+	wg.Done()
+	wg.Done()
+	wg.Done()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wg.Wait()
+	}()
+	r := waitReports(t, mu, reports, 1)[0]
+	wg.Add(-2)
+	<-done
+
+	if r.Kind != WaitGroupTimeout || r.Lock.Mutex != &wg {
+		t.Error(r.Kind, r.Lock.Mutex)
+	}
+	if len(r.Holders) != 2 {
+		t.Fatal(r.Holders)
+	}
+	if !hasFunction(r.Holders[0], "addTwo") || r.Holders[0].Count != 1 {
+		t.Error(r.Holders[0])
+	}
+	if !hasFunction(r.Holders[1], "TestDeadlockWaitGroup_Timeout") || r.Holders[1].Count != 1 {
+		t.Error(r.Holders[1])
+	}
+}
+
+func TestWaitGroup(t *testing.T) {
+	var wg WaitGroup
+	wg.Add(1)
+	go wg.Done()
+	wg.Wait()
+}
@@ -0,0 +1,216 @@
+package deadlock
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A waiter is a goroutine waiting to lock a mutex, or on a Cond or WaitGroup.
+type waiter struct {
+	kind     ReportKind    // reported when waiting too long; LockTimeout, CondTimeout or WaitGroupTimeout
+	gid      int64         // the waiting goroutine
+	stack    []uintptr     // where the wait started
+	mtx      interface{}   // what is waited on
+	read     bool          // waiting for a read lock
+	since    time.Time     // when the wait started
+	timeout  time.Duration // Opts.DeadlockTimeout when the wait started, zero if disabled
+	index    int           // in waiters.list, or -1 once the wait is over
+	reported bool          // whether the wait has been reported as timed out
+
+	inCycle       bool // whether the last check found the waiter in a wait cycle
+	cycleReported bool // whether the waiter has been reported as part of a wait cycle
+}
+
+// waiters is the registry of goroutines currently waiting, which a single
+// watchdog goroutine per Detector scans for those that have waited too long,
+// along with the locks held longer than Opts.MaxHoldTime.
+type waiters struct {
+	holds         int32 // number of locks held with a maxHold, accessed atomically
+	watchingHolds int32 // non-zero while the watchdog checks held locks, accessed atomically
+
+	mu      sync.Mutex // protects following
+	list    []*waiter
+	running bool          // whether the watchdog goroutine is running
+	sleep   time.Duration // how long the watchdog sleeps before its next check
+	wake    chan struct{} // wakes the watchdog for an earlier check
+}
+
+// startWait registers goroutine gid as waiting on mtx, starting the watchdog
+// if needed. d.endWait must be called once the wait is over. Returns nil if
+// Opts.DeadlockTimeout is zero, unless waiting for a lock with Opts.DetectWaitCycles set.
+func (d *Detector) startWait(kind ReportKind, gid int64, curStack []uintptr, mtx interface{}, read bool) *waiter {
+	to := atomic.LoadInt32(&d.deadlockTimeout)
+	if to <= 0 && (kind != LockTimeout || atomic.LoadInt32(&d.detectWaitCycles) == 0) {
+		return nil
+	}
+	if to < 0 {
+		to = 0
+	}
+	w := &waiter{kind: kind, gid: gid, stack: curStack, mtx: mtx, read: read, since: time.Now(), timeout: time.Duration(to) * time.Millisecond}
+	interval := d.checkInterval(w.timeout)
+	d.waiters.mu.Lock()
+	w.index = len(d.waiters.list)
+	d.waiters.list = append(d.waiters.list, w)
+	d.runWatchdog(interval)
+	d.waiters.mu.Unlock()
+	return w
+}
+
+// startHold counts a lock held with maxHold, and makes sure the watchdog
+// checks it. d.endHold must be called once it is released.
+func (d *Detector) startHold(maxHold time.Duration) {
+	// counted before checking watchingHolds, which the watchdog clears
+	// before checking the count, so that one of them sees the other
+	atomic.AddInt32(&d.waiters.holds, 1)
+	if atomic.LoadInt32(&d.waiters.watchingHolds) != 0 {
+		return
+	}
+	d.waiters.mu.Lock()
+	atomic.StoreInt32(&d.waiters.watchingHolds, 1)
+	d.runWatchdog(d.checkInterval(maxHold))
+	d.waiters.mu.Unlock()
+}
+
+// endHold uncounts a lock held with a maxHold.
+func (d *Detector) endHold() {
+	atomic.AddInt32(&d.waiters.holds, -1)
+}
+
+// runWatchdog starts the watchdog if it is not running, or wakes it if
+// it sleeps for longer than interval. Must be called with d.waiters.mu held.
+func (d *Detector) runWatchdog(interval time.Duration) {
+	if !d.waiters.running {
+		d.waiters.running = true
+		d.waiters.sleep = interval
+		if d.waiters.wake == nil {
+			d.waiters.wake = make(chan struct{}, 1)
+		}
+		go d.watchdog(interval)
+	} else if interval < d.waiters.sleep {
+		d.waiters.sleep = interval
+		select {
+		case d.waiters.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// endWait removes w from the registry. Does nothing if w is nil.
+func (d *Detector) endWait(w *waiter) {
+	if w == nil {
+		return
+	}
+	d.waiters.mu.Lock()
+	list := d.waiters.list
+	last := list[len(list)-1]
+	list[w.index] = last
+	last.index = w.index
+	list[len(list)-1] = nil
+	d.waiters.list = list[:len(list)-1]
+	w.index = -1
+	d.waiters.mu.Unlock()
+}
+
+// watchdog reports waiters that have waited longer than their timeout, and locks
+// held longer than Opts.MaxHoldTime, checking every interval until there are
+// no waiters left and no locks held with a MaxHoldTime.
+func (d *Detector) watchdog(interval time.Duration) {
+	t := time.NewTimer(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-d.waiters.wake:
+			if !t.Stop() {
+				<-t.C
+			}
+		}
+		if interval = d.checkWaiters(); interval == 0 {
+			return
+		}
+		t.Reset(interval)
+	}
+}
+
+// checkInterval returns how often to check a waiter with the given timeout;
+// Opts.WatchdogInterval, or if that is zero a tenth of the timeout, or
+// defaultWatchdogInterval if there is no timeout.
+func (d *Detector) checkInterval(timeout time.Duration) time.Duration {
+	interval := time.Duration(atomic.LoadInt32(&d.watchdogInterval)) * time.Millisecond
+	if interval <= 0 {
+		interval = timeout / 10
+		if timeout <= 0 {
+			interval = defaultWatchdogInterval
+		}
+	}
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	return interval
+}
+
+// checkWaiters reports the waiters that have timed out and not yet been reported,
+// the wait cycles among them if Opts.DetectWaitCycles is set, and the locks held
+// longer than Opts.MaxHoldTime. Returns how long to sleep before checking again,
+// or zero, marking the watchdog as stopped, if there are no waiters and no
+// locks held with a MaxHoldTime.
+func (d *Detector) checkWaiters() (sleep time.Duration) {
+	now := time.Now()
+	detectCycles := atomic.LoadInt32(&d.detectWaitCycles) != 0
+	var expired, waiting []*waiter
+	d.waiters.mu.Lock()
+	for _, w := range d.waiters.list {
+		if detectCycles && !w.cycleReported {
+			waiting = append(waiting, w)
+		}
+		if !w.reported {
+			if w.timeout > 0 && now.Sub(w.since) >= w.timeout {
+				w.reported = true
+				expired = append(expired, w)
+			} else if interval := d.checkInterval(w.timeout); sleep == 0 || interval < sleep {
+				sleep = interval
+			}
+		}
+	}
+	if sleep == 0 && len(d.waiters.list) > 0 {
+		// only waiters already reported; keep checking for new ones
+		sleep = d.checkInterval(time.Duration(atomic.LoadInt32(&d.deadlockTimeout)) * time.Millisecond)
+	}
+	atomic.StoreInt32(&d.waiters.watchingHolds, 0)
+	holds := atomic.LoadInt32(&d.waiters.holds) > 0
+	if holds {
+		atomic.StoreInt32(&d.waiters.watchingHolds, 1)
+		maxHold := time.Duration(atomic.LoadInt32(&d.maxHoldTime)) * time.Millisecond
+		if interval := d.checkInterval(maxHold); sleep == 0 || interval < sleep {
+			sleep = interval
+		}
+	}
+	d.waiters.running = sleep > 0
+	d.waiters.sleep = sleep
+	d.waiters.mu.Unlock()
+	for _, w := range expired {
+		d.reportWait(w)
+	}
+	if holds {
+		if held := d.lo.expiredHolds(now); len(held) > 0 {
+			d.lo.reportHoldTimeouts(held)
+		}
+	}
+	if len(waiting) > 1 {
+		d.checkWaitCycles(waiting)
+	}
+	return
+}
+
+// reportWait reports w as having waited longer than w.timeout.
+func (d *Detector) reportWait(w *waiter) {
+	switch w.kind {
+	case CondTimeout:
+		w.mtx.(*DeadlockCond).reportTimeout(d, w)
+	case WaitGroupTimeout:
+		w.mtx.(*DeadlockWaitGroup).reportTimeout(d, w)
+	default:
+		d.lo.reportTimeout(w)
+	}
+}
@@ -0,0 +1,128 @@
+package deadlock
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func numWaiters(d *Detector) (n int, running bool) {
+	d.waiters.mu.Lock()
+	defer d.waiters.mu.Unlock()
+	return len(d.waiters.list), d.waiters.running
+}
+
+func TestWatchdog(t *testing.T) {
+	d, mu, reports := newTestDetector()
+	d.Opts.WriteLocked(func() {
+		d.Opts.DeadlockTimeout = time.Millisecond * 20
+		d.Opts.WatchdogInterval = time.Millisecond * 5
+	})
+
+	const waiting = 8
+	var a DeadlockMutex
+	a.SetDetector(d)
+	a.Lock()
+	var wg sync.WaitGroup
+	for i := 0; i < waiting; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.Lock()
+			unlock(&a)
+		}()
+	}
+
+	got := waitReports(t, mu, reports, waiting)
+	for _, r := range got {
+		if r.Kind != LockTimeout || r.Timeout != time.Millisecond*20 || r.Lock.Mutex != &a {
+			t.Error(r.Kind, r.Timeout, r.Lock.Mutex)
+		}
+	}
+	if n, running := numWaiters(d); n != waiting || !running {
+		t.Error("expected", waiting, "waiters and a running watchdog, got", n, running)
+	}
+
+	// each wait is reported only once
+	time.Sleep(time.Millisecond * 30)
+	mu.Lock()
+	if len(*reports) != waiting {
+		t.Error("expected", waiting, "reports, got", len(*reports))
+	}
+	mu.Unlock()
+
+	unlock(&a)
+	wg.Wait()
+	for waited := 0; waited < 1000; waited++ {
+		if _, running := numWaiters(d); !running {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("expected the watchdog to stop once there are no waiters")
+}
+
+func TestWatchdog_Disabled(t *testing.T) {
+	d, _, _ := newTestDetector()
+	var a DeadlockMutex
+	a.SetDetector(d)
+	a.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Lock()
+		unlock(&a)
+	}()
+	time.Sleep(time.Millisecond * 5)
+	if n, running := numWaiters(d); n != 0 || running {
+		t.Error("expected no waiters when DeadlockTimeout is zero, got", n, running)
+	}
+	unlock(&a)
+	<-done
+}
+
+func TestWatchdog_HoldTimeout(t *testing.T) {
+	d, mu, reports := newTestDetector()
+	d.Opts.WriteLocked(func() {
+		d.Opts.MaxHoldTime = time.Millisecond * 20
+		d.Opts.WatchdogInterval = time.Millisecond * 5
+	})
+
+	var a, b DeadlockMutex
+	a.SetDetector(d)
+	b.SetDetector(d)
+	a.Lock()
+	b.Lock()
+	unlock(&b)
+	r := waitReports(t, mu, reports, 1)[0]
+	if r.Kind != HoldTimeout || r.Timeout != time.Millisecond*20 || r.Lock.Mutex != &a {
+		t.Error(r.Kind, r.Timeout, r.Lock.Mutex)
+	}
+
+	// each hold is reported only once
+	time.Sleep(time.Millisecond * 30)
+	mu.Lock()
+	if len(*reports) != 1 {
+		t.Error("expected 1 report, got", len(*reports))
+	}
+	mu.Unlock()
+	if _, running := numWaiters(d); !running {
+		t.Error("expected the watchdog to run while a lock is held")
+	}
+	unlock(&a)
+
+	for waited := 0; waited < 1000; waited++ {
+		if _, running := numWaiters(d); !running {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, running := numWaiters(d); running {
+		t.Fatal("expected the watchdog to stop once nothing is held")
+	}
+
+	// and to start again with the next lock held too long
+	a.Lock()
+	waitReports(t, mu, reports, 2)
+	unlock(&a)
+}